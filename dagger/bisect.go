@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	bisectGood = "good"
+	bisectBad  = "bad"
+	bisectSkip = "skip"
+)
+
+// The result of bisecting an engine regression
+type BisectResult struct {
+	// The last release that passed the check
+	LastGoodRelease string
+	// The first release that failed the check
+	FirstBadRelease string
+	// The first commit that failed the check, between the last good and first bad releases.
+	// Empty if commits were not bisected.
+	FirstBadCommit string
+	// Every step of the bisection, in order
+	Steps []*BisectStep
+}
+
+// A step in a bisection: one candidate engine, built and checked
+type BisectStep struct {
+	// "release" or "commit"
+	Kind string
+	// The release version or commit being checked
+	Candidate string
+	// One of "good", "bad" or "skip" (the candidate could not be built)
+	Status string
+	// How long the step took, eg. "3m12s"
+	Duration string
+	// The error returned by the check or the build, if any
+	Error string
+}
+
+// A human-readable log of the bisection
+func (r *BisectResult) Log() string {
+	var b strings.Builder
+	for i, step := range r.Steps {
+		fmt.Fprintf(&b, "%3d. %-7s %-40s %-4s (%s)\n", i+1, step.Kind, step.Candidate, step.Status, step.Duration)
+		if step.Error != "" {
+			for _, line := range strings.Split(strings.TrimSpace(step.Error), "\n") {
+				fmt.Fprintf(&b, "       %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "\nlast good release: %s\nfirst bad release: %s\n", r.LastGoodRelease, r.FirstBadRelease)
+	if r.FirstBadCommit != "" {
+		fmt.Fprintf(&b, "first bad commit: %s\n", r.FirstBadCommit)
+	}
+	return b.String()
+}
+
+// Find which engine version introduced a regression.
+//
+//	Releases between `good` and `bad` are bisected first. Then, unless disabled,
+//	the commits between the last good release and the first bad release.
+//	Each candidate engine is built from source, and the `check` container is run
+//	against it, with a matching Dagger CLI installed at /usr/local/bin/dagger.
+//	The check passes if its command succeeds.
+func (e *Engine) Bisect(
+	ctx context.Context,
+	// A release known to pass the check, eg. "0.10.0"
+	good string,
+	// A release known to fail the check, eg. "0.11.9"
+	bad string,
+	// The container to run against each candidate engine
+	check *Container,
+	// Command to run in the check container. Defaults to its default arguments.
+	// +optional
+	args []string,
+	// Only bisect releases, not the commits between them
+	// +optional
+	releasesOnly bool,
+) (*BisectResult, error) {
+	goodVersion, err := parseSemver(good)
+	if err != nil {
+		return nil, err
+	}
+	badVersion, err := parseSemver(bad)
+	if err != nil {
+		return nil, err
+	}
+	if goodVersion.Compare(badVersion) >= 0 {
+		return nil, fmt.Errorf("good release %s must be older than bad release %s", good, bad)
+	}
+	versions, err := e.semvers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	// candidates are the releases after `good`, up to and including `bad`
+	var candidates []string
+	for _, v := range versions {
+		if v.Compare(goodVersion) > 0 && v.Compare(badVersion) < 0 {
+			candidates = append(candidates, v.String())
+		}
+	}
+	candidates = append(candidates, badVersion.String())
+
+	result := new(BisectResult)
+	i := bisect(candidates, func(version string) string {
+		source := e.Release(version).Source()
+		return result.step(ctx, "release", version, source.Worker().WithVersion("v"+version), check, args)
+	})
+	result.FirstBadRelease = candidates[i]
+	// the most recent release checked good, which may be more recent than `good`
+	lastGood := goodVersion
+	for _, step := range result.Steps {
+		if v, err := parseSemver(step.Candidate); err == nil && step.Status == bisectGood && v.Compare(lastGood) > 0 {
+			lastGood = v
+		}
+	}
+	result.LastGoodRelease = lastGood.String()
+	if releasesOnly {
+		return result, nil
+	}
+
+	commits, err := releaseCommits(ctx, result.LastGoodRelease, result.FirstBadRelease)
+	if err != nil {
+		return result, err
+	}
+	if len(commits) == 0 {
+		return result, nil
+	}
+	// the last commit is the first bad release, known bad: it will not be checked again
+	j := bisect(commits, func(commit string) string {
+		source := &EngineSource{
+			Source:   dag.Git(engineUpstream).Commit(commit).Tree(),
+			Revision: commit,
+		}
+		return result.step(ctx, "commit", commit, source.Worker(), check, args)
+	})
+	result.FirstBadCommit = commits[j]
+	return result, nil
+}
+
+// Build a candidate worker, run the check against it, and record the step
+func (r *BisectResult) step(
+	ctx context.Context,
+	kind, candidate string,
+	worker *Worker,
+	check *Container,
+	args []string,
+) string {
+	start := time.Now()
+	step := &BisectStep{
+		Kind:      kind,
+		Candidate: candidate,
+	}
+	r.Steps = append(r.Steps, step)
+	defer func() {
+		step.Duration = time.Since(start).Round(time.Second).String()
+	}()
+
+	cli := worker.Engine.CLI("linux", "", "", worker.Version)
+	workerCtr, err := worker.Container("")
+	if err == nil {
+		_, err = workerCtr.Sync(ctx)
+	}
+	if err != nil {
+		step.Status, step.Error = bisectSkip, err.Error()
+		return step.Status
+	}
+	if _, err := cli.Sync(ctx); err != nil {
+		step.Status, step.Error = bisectSkip, err.Error()
+		return step.Status
+	}
+	ctr, err := worker.withClient(check, "dagger-bisect-"+candidate)
+	if err != nil {
+		step.Status, step.Error = bisectSkip, err.Error()
+		return step.Status
+	}
+	if len(args) > 0 {
+		ctr = ctr.WithExec(args)
+	} else {
+		ctr = ctr.WithExec(nil)
+	}
+	if _, err := ctr.Sync(ctx); err != nil {
+		step.Status, step.Error = bisectBad, err.Error()
+		return step.Status
+	}
+	step.Status = bisectGood
+	return step.Status
+}
+
+// Binary search for the first bad candidate. The last candidate is assumed bad,
+// and the one before the first is assumed good. Candidates that can't be checked
+// are skipped. Returns the index of the first bad candidate.
+func bisect(candidates []string, check func(string) string) int {
+	// indexes of the candidates still in play
+	remaining := make([]int, len(candidates))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	lo, hi := -1, len(remaining)-1
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		switch check(candidates[remaining[mid]]) {
+		case bisectGood:
+			lo = mid
+		case bisectBad:
+			hi = mid
+		default:
+			remaining = append(remaining[:mid], remaining[mid+1:]...)
+			hi--
+		}
+	}
+	return remaining[hi]
+}
+
+// List the commits between two releases, oldest first, using supergit.
+// The last commit is the one tagged by the `to` release.
+func releaseCommits(ctx context.Context, from, to string) ([]string, error) {
+	fromTag, toTag := "v"+from, "v"+to
+	repo := dag.Supergit().Repository().
+		WithGitCommand([]string{
+			"fetch", "--no-tags", engineUpstream,
+			"refs/tags/" + fromTag + ":refs/tags/" + fromTag,
+			"refs/tags/" + toTag + ":refs/tags/" + toTag,
+		})
+	out, err := repo.
+		GitCommand([]string{"rev-list", "--reverse", "--first-parent", fromTag + ".." + toTag}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
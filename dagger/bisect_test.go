@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBisect(t *testing.T) {
+	for _, tc := range []struct {
+		// status of each candidate: "g" good, "b" bad, "s" skip
+		statuses string
+		want     int
+	}{
+		{"b", 0},
+		{"gb", 1},
+		{"bb", 0},
+		{"gggbbbb", 3},
+		{"gggggbb", 5},
+		{"bbbbbbb", 0},
+		{"ggggggb", 6},
+		// a skipped first bad candidate can't be told apart from the next bad one
+		{"gggsbbb", 4},
+		{"ggsssbb", 5},
+		{"sssssss", 6},
+		{"gsgsgsb", 6},
+		{"sbbbbbb", 1},
+	} {
+		candidates := make([]string, len(tc.statuses))
+		statuses := make(map[string]string)
+		for i := range candidates {
+			candidates[i] = strconv.Itoa(i)
+			switch tc.statuses[i] {
+			case 'g':
+				statuses[candidates[i]] = bisectGood
+			case 'b':
+				statuses[candidates[i]] = bisectBad
+			default:
+				statuses[candidates[i]] = bisectSkip
+			}
+		}
+		checked := make(map[string]bool)
+		got := bisect(candidates, func(c string) string {
+			if checked[c] {
+				t.Errorf("%s: candidate %s checked twice", tc.statuses, c)
+			}
+			checked[c] = true
+			return statuses[c]
+		})
+		if got != tc.want {
+			t.Errorf("bisect(%s) = %d, want %d", tc.statuses, got, tc.want)
+		}
+	}
+}
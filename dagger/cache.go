@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	localCacheDir = "/var/lib/dagger-cache"
+)
+
+// A backend for importing and exporting the worker cache
+type CacheBackend struct {
+	// One of "s3", "registry" or "local"
+	Type string
+	// Cache attributes, as "key=value"
+	Attributes []string
+	// Credentials, injected in the worker as environment variables
+	// +private
+	Secrets []*CacheSecret
+	// A service providing the backend, eg. a local MinIO or registry.
+	// +private
+	Service *Service
+	// Hostname to bind the service to
+	// +private
+	ServiceHost string
+	// Cache volume backing a local cache
+	// +private
+	Volume string
+}
+
+// A secret environment variable
+type CacheSecret struct {
+	Name  string
+	Value *Secret
+}
+
+// Cache the worker state in an S3-compatible bucket
+func (d *Dagger) S3Cache(
+	// Name of the bucket
+	bucket string,
+	// Region of the bucket
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Endpoint of an S3-compatible service, eg. "http://minio:9000". Defaults to AWS.
+	// +optional
+	endpoint string,
+	// Access key ID. Defaults to the worker environment.
+	// +optional
+	accessKeyID *Secret,
+	// Secret access key. Defaults to the worker environment.
+	// +optional
+	secretAccessKey *Secret,
+	// Address the bucket by path instead of by subdomain. Required by most S3-compatible services.
+	// +optional
+	usePathStyle bool,
+	// A service providing the endpoint, eg. a local MinIO server
+	// +optional
+	service *Service,
+) (*CacheBackend, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	b := &CacheBackend{
+		Type:       "s3",
+		Attributes: []string{"bucket=" + bucket, "region=" + region},
+	}
+	if endpoint != "" {
+		b.Attributes = append(b.Attributes, "endpoint_url="+endpoint)
+	}
+	if usePathStyle {
+		b.Attributes = append(b.Attributes, "use_path_style=true")
+	}
+	if accessKeyID != nil {
+		b.Secrets = append(b.Secrets, &CacheSecret{Name: "AWS_ACCESS_KEY_ID", Value: accessKeyID})
+	}
+	if secretAccessKey != nil {
+		b.Secrets = append(b.Secrets, &CacheSecret{Name: "AWS_SECRET_ACCESS_KEY", Value: secretAccessKey})
+	}
+	if service != nil {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("a valid endpoint is required to bind the cache service: %q", endpoint)
+		}
+		b.Service, b.ServiceHost = service, u.Hostname()
+	}
+	return b, nil
+}
+
+// Cache the worker state in a container registry
+func (d *Dagger) RegistryCache(
+	// Image reference to store the cache, eg. "registry:5000/dagger-cache"
+	ref string,
+	// Connect to the registry over plain HTTP
+	// +optional
+	insecure bool,
+	// A service providing the registry, eg. a local registry:2 container
+	// +optional
+	service *Service,
+) *CacheBackend {
+	b := &CacheBackend{
+		Type:       "registry",
+		Attributes: []string{"ref=" + ref},
+	}
+	if insecure {
+		b.Attributes = append(b.Attributes, "registry.insecure=true")
+	}
+	if service != nil {
+		host, _, _ := strings.Cut(registryHost(ref), ":")
+		b.Service, b.ServiceHost = service, host
+	}
+	return b
+}
+
+// Cache the worker state in a local directory, backed by a cache volume
+func (d *Dagger) LocalCache(
+	// Name of the cache volume
+	// +optional
+	// +default="dagger-worker-cache"
+	volume string,
+) *CacheBackend {
+	if volume == "" {
+		volume = "dagger-worker-cache"
+	}
+	return &CacheBackend{
+		Type:       "local",
+		Attributes: []string{"src=" + localCacheDir, "dest=" + localCacheDir},
+		Volume:     volume,
+	}
+}
+
+// The cache configuration, in the format of _EXPERIMENTAL_DAGGER_CACHE_CONFIG
+func (b *CacheBackend) Config(
+	// Cache export mode: "min" only exports the final layers, "max" exports all intermediate layers
+	// +optional
+	// +default="max"
+	mode string,
+) (string, error) {
+	if mode == "" {
+		mode = "max"
+	}
+	if mode != "min" && mode != "max" {
+		return "", fmt.Errorf("invalid cache mode %q: must be min or max", mode)
+	}
+	attrs := append([]string{"type=" + b.Type, "mode=" + mode}, b.Attributes...)
+	for _, attr := range attrs {
+		if strings.Contains(attr, ",") {
+			return "", fmt.Errorf("invalid cache attribute %q: must not contain a comma", attr)
+		}
+	}
+	return strings.Join(attrs, ","), nil
+}
+
+// Import and export the worker cache to the given backend
+func (w *Worker) WithCacheConfig(
+	backend *CacheBackend,
+	// Cache export mode: "min" only exports the final layers, "max" exports all intermediate layers
+	// +optional
+	// +default="max"
+	mode string,
+) (*Worker, error) {
+	config, err := backend.Config(mode)
+	if err != nil {
+		return nil, err
+	}
+	w.Cache = backend
+	w.CacheConfig = config
+	return w, nil
+}
+
+// Enable or disable DNS resolution of services in the worker
+func (w *Worker) WithServicesDNS(enabled bool) *Worker {
+	w.DisableServicesDNS = !enabled
+	return w
+}
+
+// Wire a worker container to the services it uses when running
+func (w *Worker) withRuntime(ctr *Container) *Container {
+	if w.Telemetry != nil {
+		ctr = w.Telemetry.Install(ctr)
+	}
+	return w.withCache(ctr)
+}
+
+// Install the cache configuration in a worker container
+func (w *Worker) withCache(ctr *Container) *Container {
+	if w.DisableServicesDNS {
+		ctr = ctr.WithEnvVariable(ServicesDNSEnvName, "0")
+	}
+	if w.Cache == nil {
+		return ctr
+	}
+	ctr = ctr.WithEnvVariable(CacheConfigEnvName, w.CacheConfig)
+	for _, s := range w.Cache.Secrets {
+		ctr = ctr.WithSecretVariable(s.Name, s.Value)
+	}
+	if w.Cache.Service != nil {
+		ctr = ctr.WithServiceBinding(w.Cache.ServiceHost, w.Cache.Service)
+	}
+	if w.Cache.Volume != "" {
+		ctr = ctr.WithMountedCache(localCacheDir, dag.CacheVolume(w.Cache.Volume))
+	}
+	return ctr
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The notes of one release, in a changelog
+type changelogEntry struct {
+	Version string `json:"version"`
+	Notes   string `json:"notes"`
+}
+
+// The release notes of this release, from the changelog in its source tree
+func (r *EngineRelease) Notes(ctx context.Context) (string, error) {
+	return releaseNotes(ctx, r.Source().Source, r.Version)
+}
+
+// Combine the release notes of all releases in a range, newest first.
+//
+//	Releases after `from`, up to and including `to`, are included: this is what
+//	changes when upgrading from `from` to `to`.
+func (e *Engine) Changelog(
+	ctx context.Context,
+	// The release to upgrade from, eg. "0.10.0"
+	from string,
+	// The release to upgrade to, eg. "0.11.9". Defaults to the latest release.
+	// +optional
+	to string,
+	// Output format: "markdown" or "json"
+	// +optional
+	// +default="markdown"
+	format string,
+) (string, error) {
+	if format == "" {
+		format = "markdown"
+	}
+	if format != "markdown" && format != "json" {
+		return "", fmt.Errorf("unsupported format %q: must be markdown or json", format)
+	}
+	if to == "" {
+		latest, err := e.Latest(ctx)
+		if err != nil {
+			return "", err
+		}
+		to = latest.Version
+	}
+	c, err := parseSemverConstraint(fmt.Sprintf(">%s <=%s", from, to))
+	if err != nil {
+		return "", err
+	}
+	versions, err := e.semvers(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	// the changelog at `to` includes the notes of all previous releases
+	tree := e.Release(to).Source().Source
+	var entries []*changelogEntry
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !c.Check(versions[i]) {
+			continue
+		}
+		notes, err := releaseNotes(ctx, tree, versions[i].String())
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, &changelogEntry{Version: versions[i].String(), Notes: notes})
+	}
+	if format == "json" {
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(strings.TrimSpace(entry.Notes))
+		b.WriteString("\n\n")
+	}
+	return b.String(), nil
+}
+
+// Read the notes of a release from an engine source tree.
+//
+//	Release notes are written by changie, in .changes/<version>.md.
+//	Older releases are looked up in CHANGELOG.md.
+func releaseNotes(ctx context.Context, tree *Directory, version string) (string, error) {
+	tag := "v" + version
+	entries, err := tree.Entries(ctx, DirectoryEntriesOpts{Path: ".changes"})
+	if err == nil && contains(entries, tag+".md") {
+		return tree.File(".changes/" + tag + ".md").Contents(ctx)
+	}
+	changelog, err := tree.File("CHANGELOG.md").Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("no release notes found for %s: %w", tag, err)
+	}
+	notes := changelogSection(changelog, tag)
+	if notes == "" {
+		return "", fmt.Errorf("no release notes found for %s", tag)
+	}
+	return notes, nil
+}
+
+// Extract the section of a release from a changelog, eg. "## v0.11.9 - 2024-06-20"
+func changelogSection(changelog, tag string) string {
+	var (
+		section []string
+		found   bool
+	)
+	for _, line := range strings.Split(changelog, "\n") {
+		if strings.HasPrefix(line, "## ") {
+			if found {
+				break
+			}
+			heading := strings.Fields(strings.TrimPrefix(line, "## "))
+			found = len(heading) > 0 && heading[0] == tag
+		}
+		if found {
+			section = append(section, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(section, "\n"))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultComponentMirror = "https://github.com"
+
+// Expected SHA256 digests of the default worker components, by release and file.
+//
+//	Every architecture in knownArches needs the digests of runcVersion and cniVersion,
+//	taken from the checksums published on the GitHub release pages.
+var pinnedComponentChecksums = map[string]map[string]string{}
+
+// The expected SHA256 digest of a downloaded worker component
+type ComponentChecksum struct {
+	// Release of the component, eg. "v1.1.5"
+	Version string
+	// Name of the downloaded file, eg. "runc.amd64" or "cni-plugins-linux-amd64-v1.2.0.tgz"
+	File string
+	// Expected SHA256 digest, in hex
+	SHA256 string
+}
+
+// Set the version of runc to install in the worker
+func (w *Worker) WithRunc(
+	// runc release, eg. "v1.1.5"
+	version string,
+) *Worker {
+	w.RuncVersion = version
+	return w
+}
+
+// Set the version of the CNI plugins to install in the worker
+func (w *Worker) WithCNIPlugins(
+	// CNI plugins release, eg. "v1.2.0"
+	version string,
+) *Worker {
+	w.CNIVersion = version
+	return w
+}
+
+// Set the image to copy qemu binaries from.
+// Pin it by digest (eg. "tonistiigi/binfmt@sha256:...") to verify its contents.
+func (w *Worker) WithQemuImage(image string) *Worker {
+	w.QemuImage = image
+	return w
+}
+
+// Pin the expected SHA256 digest of a downloaded component.
+//
+//	Downloading a component without a pinned digest fails, unless published
+//	checksums are allowed. Digests pinned for the default releases are overridden.
+func (w *Worker) WithComponentChecksum(
+	// Release of the component, eg. "v1.1.5"
+	version string,
+	// Name of the downloaded file, eg. "runc.amd64" or "cni-plugins-linux-amd64-v1.2.0.tgz"
+	file string,
+	// Expected SHA256 digest, in hex
+	sha256 string,
+) *Worker {
+	for _, c := range w.Checksums {
+		if c.Version == version && c.File == file {
+			c.SHA256 = sha256
+			return w
+		}
+	}
+	w.Checksums = append(w.Checksums, &ComponentChecksum{Version: version, File: file, SHA256: sha256})
+	return w
+}
+
+// Verify components without a pinned digest against the checksums published alongside them.
+//
+//	The checksums are downloaded from the same origin as the components, eg. the component
+//	mirror: this protects against corrupted downloads, but not against a compromised origin.
+func (w *Worker) WithPublishedChecksums(allowed bool) *Worker {
+	w.AllowPublishedChecksums = allowed
+	return w
+}
+
+// Download components from a mirror of GitHub releases, eg. an internal file server.
+//
+//	The mirror must follow the GitHub layout:
+//	<url>/opencontainers/runc/releases/download/<version>/runc.<arch>
+func (w *Worker) WithComponentMirror(url string) *Worker {
+	w.ComponentMirror = strings.TrimSuffix(url, "/")
+	return w
+}
+
+// The URL of a file in a GitHub release, rewritten to the component mirror if set
+func (w *Worker) releaseURL(repo, version, file string) string {
+	mirror := w.ComponentMirror
+	if mirror == "" {
+		mirror = defaultComponentMirror
+	}
+	return fmt.Sprintf("%s/%s/releases/download/%s/%s", mirror, repo, version, file)
+}
+
+// The pinned digest of a component release file, or an empty string
+func (w *Worker) checksum(version, file string) string {
+	for _, c := range w.Checksums {
+		if c.Version == version && c.File == file {
+			return c.SHA256
+		}
+	}
+	return pinnedComponentChecksums[version][file]
+}
+
+// Download a component release file and verify its SHA256 digest.
+//
+//	The expected digest is the pinned one. If none is pinned, it is looked up in the
+//	given checksums file, only if published checksums are allowed.
+func (w *Worker) verifiedDownload(version, url, checksumsURL string) *File {
+	if !w.AllowPublishedChecksums {
+		checksumsURL = ""
+	}
+	return verifiedDownload(url, checksumsURL, w.checksum(version, url[strings.LastIndex(url, "/")+1:]))
+}
+
+// Download a file and verify its SHA256 digest.
+//
+//	If `digest` is empty, the expected digest is looked up in the given checksums
+//	file, in `sha256sum` format. If both are empty, the download fails.
+func verifiedDownload(url, checksumsURL, digest string) *File {
+	name := url[strings.LastIndex(url, "/")+1:]
+	ctr := dag.Container().
+		From("alpine:"+alpineVersion).
+		WithMountedFile("/download/"+name, dag.HTTP(url)).
+		WithWorkdir("/download")
+	switch {
+	case digest != "":
+		ctr = ctr.WithNewFile("/checksums", ContainerWithNewFileOpts{
+			Contents: fmt.Sprintf("%s  %s\n", digest, name),
+		})
+	case checksumsURL != "":
+		ctr = ctr.WithMountedFile("/checksums", dag.HTTP(checksumsURL))
+	default:
+		// no expected digest: fail with an explanation, rather than trust the download
+		ctr = ctr.WithNewFile("/checksums", ContainerWithNewFileOpts{})
+	}
+	return ctr.
+		WithExec([]string{"sh", "-c", `
+			set -e
+			awk -v f="$1" '$2 == f || $2 == "*" f { print $1 "  " f }' /checksums > /expected
+			if [ ! -s /expected ]; then
+				echo "no checksum found for $1: pin it with withComponentChecksum, or allow published checksums with withPublishedChecksums" >&2
+				exit 1
+			fi
+			sha256sum -c /expected
+		`, "verify", name}).
+		File("/download/" + name)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestPinnedComponentChecksums(t *testing.T) {
+	for _, a := range knownArches {
+		for _, c := range []struct {
+			version string
+			file    string
+		}{
+			{runcVersion, "runc." + a.runc},
+			{cniVersion, fmt.Sprintf("cni-plugins-linux-%s-%s.tgz", a.cni, cniVersion)},
+		} {
+			digest := pinnedComponentChecksums[c.version][c.file]
+			if digest == "" {
+				t.Errorf("%s: no pinned digest for %s %s", a.name, c.version, c.file)
+				continue
+			}
+			if b, err := hex.DecodeString(digest); err != nil || len(b) != 32 {
+				t.Errorf("%s: invalid digest for %s %s: %q", a.name, c.version, c.file, digest)
+			}
+		}
+	}
+}
+
+func TestComponentChecksumOverride(t *testing.T) {
+	w := &Worker{}
+	w.WithComponentChecksum("v1.1.5", "runc.amd64", "aaaa")
+	w.WithComponentChecksum("v1.1.5", "runc.amd64", "bbbb")
+	if got := w.checksum("v1.1.5", "runc.amd64"); got != "bbbb" {
+		t.Errorf("checksum(v1.1.5) = %q, want the latest pin", got)
+	}
+	if got := w.checksum("v1.1.12", "runc.amd64"); got != pinnedComponentChecksums["v1.1.12"]["runc.amd64"] {
+		t.Errorf("checksum(v1.1.12) = %q: a pin for another release was applied", got)
+	}
+}
@@ -7,8 +7,10 @@ import (
 )
 
 const (
-	alpineVersion  = "3.18"
-	engineUpstream = "https://github.com/dagger/dagger"
+	alpineVersion         = "3.18"
+	engineUpstream        = "https://github.com/dagger/dagger"
+	defaultWorkerRegistry = "registry.dagger.io/engine"
+	releaseDownloadURL    = "https://dl.dagger.io/dagger/releases"
 )
 
 // The Dagger Engine
@@ -20,9 +22,37 @@ func (d *Dagger) Engine() *Engine {
 type Engine struct {
 }
 
-// Return the latest release of the Dagger Engine
-func (e *Engine) Latest() (*EngineRelease, error) {
-	return nil, fmt.Errorf("not yet implemented")
+// Return the latest stable release of the Dagger Engine
+func (e *Engine) Latest(ctx context.Context) (*EngineRelease, error) {
+	return e.Resolve(ctx, "", false)
+}
+
+// Return the most recent release of the Dagger Engine matching a version constraint.
+//
+//	Examples of constraints: "0.11", "^0.11", "~0.11.2", ">=0.10 <0.12", "0.9.x || 0.10.x"
+func (e *Engine) Resolve(
+	ctx context.Context,
+	// A semver range. An empty constraint matches all versions.
+	// +optional
+	constraint string,
+	// Include pre-releases
+	// +optional
+	prerelease bool,
+) (*EngineRelease, error) {
+	c, err := parseSemverConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := e.semvers(ctx, prerelease)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if c.Check(versions[i]) {
+			return e.Release(versions[i].String()), nil
+		}
+	}
+	return nil, fmt.Errorf("no engine release matches %q", constraint)
 }
 
 // A development version of the engine source code
@@ -39,41 +69,124 @@ type EngineDev struct {
 func (dev *EngineDev) Branch(
 	// The name of the branch
 	name string,
-	// The git repository to pull from
+	// The git repository to pull from.
+	// Either a git URL, or a GitHub repository name like "dagger/dagger".
 	// +optional
 	repository string,
 ) *EngineSource {
-	if repository == "" {
-		repository = engineUpstream
-	}
 	return &EngineSource{
-		Source: dag.Git(repository).Branch(name).Tree(),
+		Source: dag.Git(gitRepositoryURL(repository)).Branch(name).Tree(),
 	}
 }
 
 // A development version of the engine source code, pulled from a pull request
-func (dev *EngineDev) PullRequest(number int) *EngineSource {
-	return dev.Branch(fmt.Sprintf("pull/%d/head", number), "")
+func (dev *EngineDev) PullRequest(
+	// The number of the pull request
+	number int,
+	// The git repository the pull request was opened against.
+	// Either a git URL, or a GitHub repository name like "dagger/dagger".
+	// +optional
+	repository string,
+) *EngineSource {
+	ref := fmt.Sprintf("pull/%d/head", number)
+	return &EngineSource{
+		Source:   dag.Git(gitRepositoryURL(repository)).Branch(ref).Tree(),
+		Revision: "refs/" + ref,
+	}
+}
+
+// A development version of the engine source code, pulled from a git commit
+func (dev *EngineDev) Commit(
+	// The commit digest
+	sha string,
+	// The git repository to pull from.
+	// Either a git URL, or a GitHub repository name like "dagger/dagger".
+	// +optional
+	repository string,
+) *EngineSource {
+	return &EngineSource{
+		Source:   dag.Git(gitRepositoryURL(repository)).Commit(sha).Tree(),
+		Revision: sha,
+	}
+}
+
+// A development version of the engine source code, from a local directory
+func (dev *EngineDev) Source(
+	// The engine source code, eg. a local checkout of github.com/dagger/dagger
+	dir *Directory,
+	// The source revision, eg. a git commit, for publishing metadata
+	// +optional
+	revision string,
+) *EngineSource {
+	return &EngineSource{
+		Source:   dir,
+		Revision: revision,
+	}
 }
 
-func (e *Engine) Versions(ctx context.Context) ([]string, error) {
+// The URL of a git repository. Defaults to the official upstream repository.
+// GitHub repository names like "dagger/dagger" are expanded to a full URL.
+func gitRepositoryURL(repository string) string {
+	switch {
+	case repository == "":
+		return engineUpstream
+	case strings.Contains(repository, "://"), strings.HasPrefix(repository, "git@"):
+		return repository
+	case strings.HasPrefix(repository, "github.com/"):
+		return "https://" + repository
+	case strings.Count(repository, "/") == 1:
+		return "https://github.com/" + repository
+	}
+	return repository
+}
+
+// List all released versions of the Dagger Engine, oldest first
+func (e *Engine) Versions(
+	ctx context.Context,
+	// Include pre-releases
+	// +optional
+	prerelease bool,
+) ([]string, error) {
+	semvers, err := e.semvers(ctx, prerelease)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(semvers))
+	for _, v := range semvers {
+		versions = append(versions, v.String())
+	}
+	return versions, nil
+}
+
+// Query upstream release tags, parsed and sorted by semver precedence
+func (e *Engine) semvers(ctx context.Context, prerelease bool) ([]semver, error) {
 	tags, err := dag.Supergit().Remote(engineUpstream).Tags(ctx, SupergitRemoteTagsOpts{Filter: "^v[0-9\\.]+"})
 	if err != nil {
 		return nil, err
 	}
-	versions := make([]string, 0, len(tags))
+	versions := make([]semver, 0, len(tags))
 	for _, tag := range tags {
 		name, err := tag.Name(ctx)
 		if err != nil {
-			return versions, err
+			return nil, err
+		}
+		v, err := parseSemver(name)
+		if err != nil {
+			// not a release tag
+			continue
 		}
-		versions = append(versions, name[1:])
+		if v.Pre != "" && !prerelease {
+			continue
+		}
+		versions = append(versions, v)
 	}
+	sortSemvers(versions)
 	return versions, nil
 }
 
+// List all stable releases of the Dagger Engine, oldest first
 func (e *Engine) Releases(ctx context.Context) ([]*EngineRelease, error) {
-	versions, err := e.Versions(ctx)
+	versions, err := e.Versions(ctx, false)
 	if err != nil {
 		return nil, err
 	}
@@ -86,23 +199,32 @@ func (e *Engine) Releases(ctx context.Context) ([]*EngineRelease, error) {
 
 type EngineRelease struct {
 	Version string
+	// Base URL to download release artifacts from
+	DownloadURL string
+	// Registry to pull the worker image from
+	WorkerRegistry string
 }
 
 func (r *EngineRelease) Source() *EngineSource {
 	return &EngineSource{
-		Source: dag.Git(engineUpstream).Tag("v" + r.Version).Tree(),
+		Source:   dag.Git(engineUpstream).Tag("v" + r.Version).Tree(),
+		Revision: "v" + r.Version,
 	}
 }
 
 // An official source release of the Dagger Engine
 func (e *Engine) Release(version string) *EngineRelease {
 	return &EngineRelease{
-		Version: version,
+		Version:        strings.TrimPrefix(version, "v"),
+		DownloadURL:    releaseDownloadURL,
+		WorkerRegistry: defaultWorkerRegistry,
 	}
 }
 
 type EngineSource struct {
 	Source *Directory
+	// The source revision, eg. a git tag or commit. Empty if unknown.
+	Revision string
 }
 
 // Supported operating systems
@@ -114,12 +236,13 @@ func (e *EngineSource) OSes() []string {
 	}
 }
 
-// Supported hardware architectures
+// Supported hardware architectures of the CLI
 func (e *EngineSource) Arches() []string {
-	return []string{
-		"x86_64",
-		"arm64",
+	arches := make([]string, 0, len(knownArches))
+	for _, a := range knownArches {
+		arches = append(arches, a.name)
 	}
+	return arches
 }
 
 // Build the Dagger CLI and return the binary
@@ -138,8 +261,11 @@ func (e *EngineSource) CLI(
 	// +optional
 	version string,
 ) *File {
+	if workerRegistry == "" {
+		workerRegistry = defaultWorkerRegistry
+	}
 	ldflags := []string{"-s", "-w"}
-	if version == "" {
+	if version != "" {
 		ldflags = append(ldflags, "-X", "github.com/dagger/dagger/engine.Version="+version)
 	}
 	ldflags = append(ldflags, fmt.Sprintf("-X github.com/dagger/dagger/engine.EngineImageRepo=%s", workerRegistry))
@@ -149,7 +275,7 @@ func (e *EngineSource) CLI(
 		base = base.WithEnvVariable("GOOS", operatingSystem)
 	}
 	if arch != "" {
-		base = base.WithEnvVariable("GOARCH", arch)
+		base = lookupArch(arch).goEnv(base)
 	}
 	return base.
 		WithExec(
@@ -158,6 +284,73 @@ func (e *EngineSource) CLI(
 		File("./bin/dagger")
 }
 
+// Build the Dagger CLI for all supported platforms, and package it for release.
+//
+//	The returned directory contains one archive per platform, named like upstream
+//	(eg. dagger_v0.11.9_linux_amd64.tar.gz, dagger_v0.11.9_windows_amd64.zip),
+//	and a checksums.txt file.
+func (e *EngineSource) CLIRelease(
+	// Version of the Dagger CLI to release, eg. "v0.11.9"
+	version string,
+	// Registry from which to auto-pull the worker container image
+	// +optional
+	// +default="registry.dagger.io/engine"
+	workerRegistry string,
+) *Directory {
+	version = "v" + strings.TrimPrefix(version, "v")
+	dist := dag.Directory()
+	for _, os := range e.OSes() {
+		for _, arch := range e.Arches() {
+			if !contains(lookupArch(arch).cliOSes, os) {
+				continue
+			}
+			name, archive := e.cliArchive(os, arch, workerRegistry, version)
+			dist = dist.WithFile(name, archive)
+		}
+	}
+	checksums := releaseTools().
+		WithMountedDirectory("/dist", dist).
+		WithWorkdir("/dist").
+		WithExec([]string{"sh", "-c", "sha256sum * > /checksums.txt"}).
+		File("/checksums.txt")
+	return dist.WithFile("checksums.txt", checksums)
+}
+
+// Build the CLI for one platform and package it as a release archive.
+// Returns the archive name and file.
+func (e *EngineSource) cliArchive(operatingSystem, arch, workerRegistry, version string) (string, *File) {
+	basename := fmt.Sprintf("dagger_%s_%s_%s", version, operatingSystem, lookupArch(arch).release)
+	binName := "dagger"
+	if operatingSystem == "windows" {
+		binName += ".exe"
+	}
+	content := dag.Directory().
+		WithFile(binName, e.CLI(operatingSystem, arch, workerRegistry, version), DirectoryWithFileOpts{
+			Permissions: 0755,
+		}).
+		WithFile("LICENSE", e.Source.File("LICENSE"))
+	ctr := releaseTools().
+		WithMountedDirectory("/src", content).
+		WithWorkdir("/src")
+	if operatingSystem == "windows" {
+		name := basename + ".zip"
+		return name, ctr.
+			WithExec([]string{"zip", "-q", "-X", "/" + name, binName, "LICENSE"}).
+			File("/" + name)
+	}
+	name := basename + ".tar.gz"
+	return name, ctr.
+		WithExec([]string{"tar", "-czf", "/" + name, binName, "LICENSE"}).
+		File("/" + name)
+}
+
+// A container with the tools needed to package release artifacts
+func releaseTools() *Container {
+	return dag.Container().
+		From("alpine:" + alpineVersion).
+		WithExec([]string{"apk", "add", "--no-cache", "tar", "zip", "coreutils"})
+}
+
 // GoBase is a standardized base image for running Go, cache optimized for the layout
 // of this engine source code
 func (e *EngineSource) GoBase() *Container {
@@ -188,7 +381,10 @@ func (e *EngineSource) GoBase() *Container {
 
 func (e *EngineSource) Worker() *Worker {
 	return &Worker{
-		GoBase: e.GoBase(),
-		Engine: e,
+		GoBase:      e.GoBase(),
+		Engine:      e,
+		RuncVersion: runcVersion,
+		CNIVersion:  cniVersion,
+		QemuImage:   qemuBinImage,
 	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The result of checking that generated files are up-to-date
+type GeneratedCheck struct {
+	// Generated files that are out of date
+	Files []*GeneratedFile
+	// A patch updating all generated files. Apply it from the root of the source tree, with `git apply`.
+	Patch *File
+}
+
+// A generated file that is out of date
+type GeneratedFile struct {
+	// Path of the file, relative to the root of the source tree
+	Path string
+	// One of "modified", "added" or "deleted"
+	Status string
+}
+
+// Whether all generated files are up to date
+func (c *GeneratedCheck) UpToDate() bool {
+	return len(c.Files) == 0
+}
+
+// Return an error if any generated file is out of date
+func (c *GeneratedCheck) Check() error {
+	if c.UpToDate() {
+		return nil
+	}
+	paths := make([]string, 0, len(c.Files))
+	for _, f := range c.Files {
+		paths = append(paths, f.Path)
+	}
+	return fmt.Errorf("%d generated files are out of date: %s", len(paths), strings.Join(paths, ", "))
+}
+
+// Run the code generators, and check that generated files in the source are up to date.
+//
+//	`go generate` is run from the root of the source tree, then the generators of
+//	the other SDKs, against a dev worker built from the same source.
+func (e *EngineSource) CheckGenerated(
+	ctx context.Context,
+	// SDKs to regenerate, in addition to `go generate ./...`
+	// +optional
+	// +default=["python", "typescript"]
+	sdks []string,
+) (*GeneratedCheck, error) {
+	if sdks == nil {
+		sdks = []string{"python", "typescript"}
+	}
+	ctr, err := e.Worker().withClient(e.GoBase(), "dagger-dev-engine-generate")
+	if err != nil {
+		return nil, err
+	}
+	generated := ctr.
+		// the code generators need a session with the engine
+		WithExec([]string{"dagger", "run", "go", "generate", "./..."}).
+		Directory("/app")
+	for _, name := range sdks {
+		src := &EngineSource{Source: generated, Revision: e.Revision}
+		sdk, err := src.SDK(name)
+		if err != nil {
+			return nil, err
+		}
+		sdkDir, err := sdk.Generate()
+		if err != nil {
+			return nil, err
+		}
+		// replace rather than merge, so files the generator no longer writes show as deleted
+		generated = generated.
+			WithoutDirectory(sdk.Path()).
+			WithDirectory(sdk.Path(), sdkDir)
+	}
+
+	ctr = dag.Container().
+		From("alpine:"+alpineVersion).
+		WithExec([]string{"apk", "add", "--no-cache", "git", "rsync"}).
+		WithDirectory("/src", e.Source, ContainerWithDirectoryOpts{Exclude: []string{".git"}}).
+		WithMountedDirectory("/generated", generated).
+		WithWorkdir("/src").
+		WithExec([]string{"sh", "-c", `
+			set -e
+			git init -q
+			git add -A
+			git -c user.name=dagger -c user.email=dagger@localhost commit -q --allow-empty -m source
+			rsync -a --delete --exclude .git /generated/ /src/
+			git add -A
+			git diff --cached --no-renames --name-status > /status
+			git diff --cached --no-renames --binary > /generated.patch
+		`})
+	status, err := ctr.File("/status").Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneratedCheck{
+		Files: parseNameStatus(status),
+		Patch: ctr.File("/generated.patch"),
+	}, nil
+}
+
+// Parse the output of `git diff --name-status`
+func parseNameStatus(output string) []*GeneratedFile {
+	var files []*GeneratedFile
+	for _, line := range strings.Split(output, "\n") {
+		code, path, ok := strings.Cut(line, "\t")
+		if !ok || code == "" {
+			continue
+		}
+		status := "modified"
+		switch code[0] {
+		case 'A':
+			status = "added"
+		case 'D':
+			status = "deleted"
+		}
+		files = append(files, &GeneratedFile{Path: path, Status: status})
+	}
+	return files
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const matrixLogPath = "/tmp/matrix.log"
+
+// The results of running a module against several engine releases
+type MatrixResult struct {
+	// One entry per engine release, oldest first
+	Entries []*MatrixEntry
+}
+
+// The result of running a module against one engine release
+type MatrixEntry struct {
+	// The engine release
+	Version string
+	// Whether the command succeeded
+	Passed bool
+	// Exit code of the command, or -1 if the worker or CLI failed to build or boot
+	ExitCode int
+	// How long the command took, including booting the engine, eg. "1m3s"
+	Duration string
+	// Combined stdout and stderr of the command, or the error that prevented running it
+	Log string
+}
+
+// Releases on which the command succeeded
+func (r *MatrixResult) Passed() []string {
+	var versions []string
+	for _, entry := range r.Entries {
+		if entry.Passed {
+			versions = append(versions, entry.Version)
+		}
+	}
+	return versions
+}
+
+// Releases on which the command failed
+func (r *MatrixResult) Failed() []string {
+	var versions []string
+	for _, entry := range r.Entries {
+		if !entry.Passed {
+			versions = append(versions, entry.Version)
+		}
+	}
+	return versions
+}
+
+// A pass/fail table of the results, in markdown
+func (r *MatrixResult) Table() string {
+	var b strings.Builder
+	b.WriteString("| Engine | Result | Exit code | Duration |\n")
+	b.WriteString("|---|---|---|---|\n")
+	for _, entry := range r.Entries {
+		result := "pass"
+		if !entry.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %d | %s |\n", entry.Version, result, entry.ExitCode, entry.Duration)
+	}
+	return b.String()
+}
+
+// Run a module against several engine releases, to find which ones it works on.
+//
+//	For each release, a worker is built from the release source, and the command
+//	is run from the module directory with a matching Dagger CLI. A release whose
+//	worker or CLI fails to build or boot is reported as failed.
+func (e *Engine) Matrix(
+	ctx context.Context,
+	// The module to test
+	module *Directory,
+	// Engine releases to test, eg. ["0.10.3", "0.11.9"].
+	// Defaults to all stable releases matching `constraint`.
+	// +optional
+	versions []string,
+	// Only test releases matching this semver range, eg. ">=0.10"
+	// +optional
+	constraint string,
+	// Command to run against each release
+	// +optional
+	// +default=["dagger", "call", "--help"]
+	command []string,
+	// Maximum number of releases to test at the same time
+	// +optional
+	// +default=4
+	concurrency int,
+) (*MatrixResult, error) {
+	if len(command) == 0 {
+		command = []string{"dagger", "call", "--help"}
+	}
+	if concurrency < 1 {
+		concurrency = 4
+	}
+	c, err := parseSemverConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	var selected []string
+	if len(versions) == 0 {
+		semvers, err := e.semvers(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range semvers {
+			if c.Check(v) {
+				selected = append(selected, v.String())
+			}
+		}
+	} else {
+		for _, version := range versions {
+			v, err := parseSemver(version)
+			if err != nil {
+				return nil, err
+			}
+			if c.Check(v) {
+				selected = append(selected, v.String())
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no engine release selected")
+	}
+
+	result := &MatrixResult{
+		Entries: make([]*MatrixEntry, len(selected)),
+	}
+	var eg errgroup.Group
+	eg.SetLimit(concurrency)
+	for i, version := range selected {
+		i, version := i, version
+		eg.Go(func() error {
+			start := time.Now()
+			entry, err := e.matrixEntry(ctx, module, version, command)
+			if err != nil {
+				// old releases failing to build is expected: report, don't abort
+				entry = &MatrixEntry{
+					Version:  version,
+					ExitCode: -1,
+					Duration: time.Since(start).Round(time.Second).String(),
+					Log:      err.Error(),
+				}
+			}
+			result.Entries[i] = entry
+			return nil
+		})
+	}
+	// entries never fail: errors are recorded in the entries
+	_ = eg.Wait()
+	return result, nil
+}
+
+// Run the command against one engine release
+func (e *Engine) matrixEntry(ctx context.Context, module *Directory, version string, command []string) (*MatrixEntry, error) {
+	start := time.Now()
+	playground, err := e.Release(version).Source().Worker().
+		WithVersion("v" + version).
+		Playground("dagger-matrix-" + version)
+	if err != nil {
+		return nil, err
+	}
+	ctr := playground.
+		WithMountedDirectory("/src", module).
+		WithWorkdir("/src")
+	ctr, exitCode, err := execWithExitCode(ctx, ctr, command, matrixLogPath)
+	if err != nil {
+		return nil, err
+	}
+	log, err := ctr.File(matrixLogPath).Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &MatrixEntry{
+		Version:  version,
+		Passed:   exitCode == 0,
+		ExitCode: exitCode,
+		Duration: time.Since(start).Round(time.Second).String(),
+		Log:      log,
+	}, nil
+}
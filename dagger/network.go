@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	workerCACertsDir = "/usr/local/share/ca-certificates"
+	// The network of the containers run by the dev worker
+	devWorkerNetworkCIDR = "10.89.0.0/16"
+)
+
+// Trust additional CA certificates in the worker.
+//
+//	The certificates are installed in the system store of the worker, and are used
+//	by the daemon and buildkit to pull images, fetch git repositories and HTTP sources.
+//	Only files with the .crt extension, in PEM format, are installed.
+func (w *Worker) WithCACerts(
+	// A directory of CA certificates
+	certs *Directory,
+) *Worker {
+	w.CACerts = certs
+	return w
+}
+
+// Use HTTP(S) proxies for all requests from the worker
+func (w *Worker) WithProxy(
+	// Proxy for HTTP requests, eg. "http://proxy.corp:3128"
+	// +optional
+	http string,
+	// Proxy for HTTPS requests. Defaults to the HTTP proxy.
+	// +optional
+	https string,
+	// Hosts, domains and networks to reach directly, eg. [".corp", "10.0.0.0/8"].
+	// Loopback, the worker network and bound services are always reached directly.
+	// +optional
+	noProxy []string,
+) (*Worker, error) {
+	if https == "" {
+		https = http
+	}
+	for _, proxy := range []string{http, https} {
+		if proxy == "" {
+			continue
+		}
+		if u, err := url.Parse(proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", proxy)
+		}
+	}
+	if http == "" && https == "" {
+		return nil, fmt.Errorf("no proxy set")
+	}
+	w.HTTPProxy = http
+	w.HTTPSProxy = https
+	w.NoProxy = noProxy
+	return w, nil
+}
+
+// Install CA certificates and proxy settings in a worker container
+func (w *Worker) withNetwork(ctr *Container) *Container {
+	if w.CACerts != nil {
+		ctr = ctr.
+			WithDirectory(workerCACertsDir, w.CACerts, ContainerWithDirectoryOpts{
+				Include: []string{"**/*.crt"},
+			}).
+			WithExec([]string{"sh", "-c", "apk add --no-cache ca-certificates && update-ca-certificates"})
+	}
+	if w.HTTPProxy == "" && w.HTTPSProxy == "" {
+		return ctr
+	}
+	// both spellings are common: Go reads the upper case, git and curl the lower case
+	for _, env := range [][2]string{
+		{"HTTP_PROXY", w.HTTPProxy},
+		{"HTTPS_PROXY", w.HTTPSProxy},
+		{"NO_PROXY", strings.Join(w.noProxy(), ",")},
+	} {
+		if env[1] == "" {
+			continue
+		}
+		ctr = ctr.
+			WithEnvVariable(env[0], env[1]).
+			WithEnvVariable(strings.ToLower(env[0]), env[1])
+	}
+	return ctr
+}
+
+// Hosts to reach without the proxy: the configured ones, loopback,
+// the worker network, and the services bound to the worker
+func (w *Worker) noProxy() []string {
+	hosts := []string{"localhost", "127.0.0.1", devWorkerNetworkCIDR}
+	// registries used by the engine integration tests
+	hosts = append(hosts, "registry", "privateregistry")
+	if w.Cache != nil && w.Cache.ServiceHost != "" {
+		hosts = append(hosts, w.Cache.ServiceHost)
+	}
+	if w.Telemetry != nil && w.Telemetry.ServiceHost != "" {
+		hosts = append(hosts, w.Telemetry.ServiceHost)
+	}
+	hosts = append(hosts, w.NoProxy...)
+	var deduped []string
+	for _, host := range hosts {
+		if !contains(deduped, host) {
+			deduped = append(deduped, host)
+		}
+	}
+	return deduped
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// A hardware architecture, and how the different tools name it
+type archSpec struct {
+	// Canonical name, as in the variant of an OCI platform, eg. "arm/v7"
+	name string
+	// GOARCH and GOARM
+	goarch string
+	goarm  string
+	// Output of `uname -m`, eg. "armv7l"
+	uname string
+	// Suffix of the CLI release archives, eg. "armv7"
+	release string
+	// Suffix of the runc binaries, eg. "armhf"
+	runc string
+	// Suffix of the CNI plugins archives, eg. "arm"
+	cni string
+	// Alpine release to use as base, when the default release doesn't support this architecture
+	alpine string
+	// Operating systems the CLI is released for
+	cliOSes []string
+}
+
+var knownArches = []archSpec{
+	{name: "amd64", goarch: "amd64", uname: "x86_64", release: "amd64", runc: "amd64", cni: "amd64",
+		cliOSes: []string{"darwin", "linux", "windows"}},
+	{name: "arm64", goarch: "arm64", uname: "aarch64", release: "arm64", runc: "arm64", cni: "arm64",
+		cliOSes: []string{"darwin", "linux", "windows"}},
+	{name: "arm/v7", goarch: "arm", goarm: "7", uname: "armv7l", release: "armv7", runc: "armhf", cni: "arm",
+		cliOSes: []string{"linux", "windows"}},
+	// riscv64 images are published since alpine 3.20
+	{name: "riscv64", goarch: "riscv64", uname: "riscv64", release: "riscv64", runc: "riscv64", cni: "riscv64", alpine: "3.20",
+		cliOSes: []string{"linux"}},
+}
+
+// Default architectures of the worker image
+var defaultWorkerArches = []string{"amd64", "arm64"}
+
+// Look up an architecture by any of its names: canonical, GOARCH, uname, release
+// suffix, or OCI platform ("linux/arm/v7"). An empty name is the native architecture.
+func parseArch(name string) (archSpec, error) {
+	if name == "" {
+		name = runtime.GOARCH
+	}
+	name = strings.TrimPrefix(name, "linux/")
+	for _, a := range knownArches {
+		if name == a.name || name == a.goarch || name == a.uname || name == a.release {
+			return a, nil
+		}
+	}
+	// arm64 is also known as arm64/v8
+	if name == "arm64/v8" {
+		return parseArch("arm64")
+	}
+	return archSpec{}, fmt.Errorf("unsupported architecture %q", name)
+}
+
+// Look up an architecture, and fall back to using the name as is, as a GOARCH
+func lookupArch(name string) archSpec {
+	a, err := parseArch(name)
+	if err != nil {
+		return archSpec{name: name, goarch: name, uname: name, release: name, runc: name, cni: name}
+	}
+	return a
+}
+
+// The OCI platform, eg. "linux/arm/v7"
+func (a archSpec) platform() Platform {
+	return Platform("linux/" + a.name)
+}
+
+// Configure a Go container to build for this architecture
+func (a archSpec) goEnv(ctr *Container) *Container {
+	ctr = ctr.WithEnvVariable("GOARCH", a.goarch)
+	if a.goarm != "" {
+		ctr = ctr.WithEnvVariable("GOARM", a.goarm)
+	}
+	return ctr
+}
+
+// The alpine image to use as base
+func (a archSpec) alpineImage() string {
+	if a.alpine != "" {
+		return "alpine:" + a.alpine
+	}
+	return "alpine:" + alpineVersion
+}
+
+// Set the architectures of the worker image, eg. ["amd64", "arm64", "arm/v7", "riscv64"]
+func (w *Worker) WithArches(arches []string) (*Worker, error) {
+	if len(arches) == 0 {
+		return nil, fmt.Errorf("no architecture set")
+	}
+	names := make([]string, 0, len(arches))
+	for _, name := range arches {
+		a, err := parseArch(name)
+		if err != nil {
+			return nil, err
+		}
+		if contains(names, a.name) {
+			return nil, fmt.Errorf("duplicate architecture %q", name)
+		}
+		names = append(names, a.name)
+	}
+	w.Architectures = names
+	return w, nil
+}
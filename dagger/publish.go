@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	spdxMediaType   = "application/spdx+json"
+	intotoMediaType = "application/vnd.in-toto+json"
+)
+
+// Publish the worker container to the given registry.
+//
+//	The published image index and each platform image are annotated with the
+//	version, source revision and creation time of the worker. An SPDX SBOM and a
+//	provenance attestation are attached to each platform image, as OCI referrers.
+//
+//	Returns the published reference, with its digest.
+func (w *Worker) Publish(
+	ctx context.Context,
+	// Where to publish the worker, eg. "registry.dagger.io/engine:v0.11.9"
+	ref string,
+	// Source revision of the engine, eg. a git commit or tag.
+	// Defaults to the revision of the engine source, if known.
+	// +optional
+	revision string,
+	// Username to authenticate to the registry
+	// +optional
+	username string,
+	// Password to authenticate to the registry
+	// +optional
+	password *Secret,
+	// Connect to the registry over plain HTTP
+	// +optional
+	insecure bool,
+) (string, error) {
+	return w.publish(ctx, w.publishTools(), ref, revision, username, password, insecure)
+}
+
+// Publish the worker to a local registry, and check its annotations and attestations
+func (w *Worker) TestPublish(ctx context.Context) error {
+	const ref = "registry:5000/dagger-engine-worker:test"
+	// the registry has no persistent storage: keep the same instance up
+	// for the push and the checks
+	reg, err := registry().Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer reg.Stop(ctx)
+	tools := w.publishTools().WithServiceBinding("registry", reg)
+	published, err := w.publish(ctx, tools, ref, "test", "", nil, true)
+	if err != nil {
+		return err
+	}
+	_, digest, _ := strings.Cut(published, "@")
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("unexpected published reference: %q", published)
+	}
+	script := fmt.Sprintf(`set -eu
+regctl registry set --tls disabled registry:5000
+regctl manifest get --format raw-body %s | jq -r '.annotations["%s"]' | grep -qx 'test'
+for platform in %s; do
+	types=$(regctl artifact list --platform "$platform" --format '{{ range .Descriptors }}{{ .ArtifactType }} {{ end }}' %s)
+	echo "$platform: $types"
+	echo "$types" | grep -q '%s'
+	echo "$types" | grep -q '%s'
+done
+`, ref, ociRevisionAnnotation, strings.Join(w.platforms(), " "), ref, spdxMediaType, intotoMediaType)
+	_, err = tools.WithExec([]string{"sh", "-c", script}).Sync(ctx)
+	return err
+}
+
+const (
+	ociVersionAnnotation  = "org.opencontainers.image.version"
+	ociRevisionAnnotation = "org.opencontainers.image.revision"
+	ociCreatedAnnotation  = "org.opencontainers.image.created"
+)
+
+// The platforms of the published worker, eg. "linux/amd64"
+func (w *Worker) platforms() []string {
+	platforms := make([]string, 0, len(w.Arches()))
+	for _, arch := range w.Arches() {
+		platforms = append(platforms, string(lookupArch(arch).platform()))
+	}
+	return platforms
+}
+
+// A container with the tools needed to publish and attest the worker
+func (w *Worker) publishTools() *Container {
+	return dag.
+		Wolfi().
+		Container(WolfiContainerOpts{
+			Packages: []string{"regclient", "syft", "jq"},
+		})
+}
+
+func (w *Worker) publish(
+	ctx context.Context,
+	tools *Container,
+	ref, revision, username string,
+	password *Secret,
+	insecure bool,
+) (string, error) {
+	if revision == "" {
+		revision = w.Engine.Revision
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+	annotations := map[string]string{
+		ociCreatedAnnotation: created,
+	}
+	if w.Version != "" {
+		annotations[ociVersionAnnotation] = w.Version
+	}
+	if revision != "" {
+		annotations[ociRevisionAnnotation] = revision
+	}
+
+	variants, err := w.Containers()
+	if err != nil {
+		return "", err
+	}
+	for i := range variants {
+		for _, key := range sortedKeys(annotations) {
+			variants[i] = variants[i].WithLabel(key, annotations[key])
+		}
+	}
+	provenance, err := w.provenance(ctx, revision, created)
+	if err != nil {
+		return "", err
+	}
+
+	ctr := tools.
+		WithMountedFile("/worker.tar", dag.Container().AsTarball(ContainerAsTarballOpts{
+			PlatformVariants: variants,
+			MediaTypes:       Ocimediatypes,
+		})).
+		WithNewFile("/provenance.json", ContainerWithNewFileOpts{Contents: provenance}).
+		WithEnvVariable("REF", ref)
+	host := registryHost(ref)
+	if insecure {
+		ctr = ctr.WithExec([]string{"regctl", "registry", "set", "--tls", "disabled", host})
+	}
+	if password != nil {
+		ctr = ctr.
+			WithSecretVariable("REGISTRY_PASSWORD", password).
+			WithExec([]string{"sh", "-c", fmt.Sprintf(
+				`echo "$REGISTRY_PASSWORD" | regctl registry login %s --user %s --pass-stdin`,
+				shellQuote(host), shellQuote(username),
+			)})
+	}
+	for i, variant := range variants {
+		ctr = ctr.WithMountedFile(fmt.Sprintf("/variants/%d.tar", i), variant.AsTarball(ContainerAsTarballOpts{
+			MediaTypes: Ocimediatypes,
+		}))
+	}
+
+	var script strings.Builder
+	script.WriteString("set -eu\n")
+	script.WriteString(`regctl image import "$REF" /worker.tar` + "\n")
+	modArgs := []string{"regctl", "image", "mod", `"$REF"`, "--replace"}
+	for _, key := range sortedKeys(annotations) {
+		// annotate the index, and every platform image
+		modArgs = append(modArgs,
+			"--annotation", shellQuote(key+"="+annotations[key]),
+			"--annotation", shellQuote("[*]"+key+"="+annotations[key]),
+		)
+	}
+	script.WriteString(strings.Join(modArgs, " ") + "\n")
+	for i, platform := range w.platforms() {
+		fmt.Fprintf(&script, `
+digest=$(regctl image digest --platform %[1]s "$REF")
+syft scan oci-archive:/variants/%[2]d.tar -o spdx-json=/sbom-%[2]d.json
+regctl artifact put --subject "$REF@$digest" --artifact-type %[3]s --file-media-type %[3]s -f /sbom-%[2]d.json
+jq --arg name "$REF" --arg digest "${digest#sha256:}" --arg platform %[1]s \
+	'.subject = [{"name": $name, "digest": {"sha256": $digest}}] | .predicate.buildDefinition.externalParameters.platform = $platform' \
+	/provenance.json > /provenance-%[2]d.json
+regctl artifact put --subject "$REF@$digest" --artifact-type %[4]s --file-media-type %[4]s -f /provenance-%[2]d.json
+`, platform, i, spdxMediaType, intotoMediaType)
+	}
+	script.WriteString(`echo -n "$REF@$(regctl image digest "$REF")"` + "\n")
+
+	return ctr.WithExec([]string{"sh", "-c", script.String()}).Stdout(ctx)
+}
+
+// An in-toto statement with a SLSA provenance predicate.
+// See https://slsa.dev/spec/v1.0/provenance
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []any               `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+type provenancePredicate struct {
+	BuildDefinition struct {
+		BuildType            string               `json:"buildType"`
+		ExternalParameters   map[string]string    `json:"externalParameters"`
+		ResolvedDependencies []provenanceResource `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn string `json:"startedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+type provenanceResource struct {
+	Name        string            `json:"name"`
+	URI         string            `json:"uri,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Generate a provenance attestation listing the versions of the worker components.
+// The subject is filled in at publish time, once the image digests are known.
+func (w *Worker) provenance(ctx context.Context, revision, created string) (string, error) {
+	goVer, err := w.GoBase.WithExec([]string{"go", "env", "GOVERSION"}).Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	var stmt provenanceStatement
+	stmt.Type = "https://in-toto.io/Statement/v1"
+	stmt.Subject = []any{}
+	stmt.PredicateType = "https://slsa.dev/provenance/v1"
+	def := &stmt.Predicate.BuildDefinition
+	def.BuildType = "https://github.com/shykes/daggerverse/tree/main/dagger#worker"
+	def.ExternalParameters = map[string]string{
+		"version":  w.Version,
+		"revision": revision,
+	}
+	def.ResolvedDependencies = []provenanceResource{
+		{
+			Name:        "runc",
+			URI:         w.releaseURL("opencontainers/runc", w.RuncVersion, ""),
+			Annotations: map[string]string{"version": w.RuncVersion},
+		},
+		{
+			Name:        "cni-plugins",
+			URI:         w.releaseURL("containernetworking/plugins", w.CNIVersion, ""),
+			Annotations: map[string]string{"version": w.CNIVersion},
+		},
+		{
+			Name:        "qemu",
+			URI:         w.QemuImage,
+			Annotations: map[string]string{"version": imageVersion(w.QemuImage)},
+		},
+		{
+			Name:        "go",
+			Annotations: map[string]string{"version": strings.TrimSpace(goVer)},
+		},
+	}
+	for _, c := range w.Checksums {
+		for i := range def.ResolvedDependencies {
+			dep := &def.ResolvedDependencies[i]
+			if strings.HasPrefix(c.File, dep.Name) && c.Version == dep.Annotations["version"] {
+				dep.Annotations["sha256:"+c.File] = c.SHA256
+			}
+		}
+	}
+	stmt.Predicate.RunDetails.Builder.ID = "https://github.com/shykes/daggerverse/tree/main/dagger"
+	stmt.Predicate.RunDetails.Metadata.StartedOn = created
+	out, err := json.MarshalIndent(stmt, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// The registry host of an image reference, eg. "registry.dagger.io" for "registry.dagger.io/engine:main"
+func registryHost(ref string) string {
+	host, rest, ok := strings.Cut(ref, "/")
+	if !ok || rest == "" || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return "docker.io"
+	}
+	return host
+}
+
+// The tag or digest of an image reference
+func imageVersion(ref string) string {
+	if _, digest, ok := strings.Cut(ref, "@"); ok {
+		return digest
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return "latest"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Download release artifacts from another base URL, eg. a local HTTP mirror.
+//
+//	The mirror must follow the upstream layout: <url>/<version>/checksums.txt
+func (r *EngineRelease) WithDownloadURL(url string) *EngineRelease {
+	r.DownloadURL = strings.TrimSuffix(url, "/")
+	return r
+}
+
+// Pull the worker image from another registry, eg. a local mirror
+func (r *EngineRelease) WithWorkerRegistry(registry string) *EngineRelease {
+	r.WorkerRegistry = registry
+	return r
+}
+
+// Download the official build of the Dagger CLI for this release.
+//
+//	The archive is verified against the published checksums before being unpacked.
+func (r *EngineRelease) CLI(
+	// Operating System of the CLI
+	// +optional
+	// +default="linux"
+	operatingSystem string,
+	// Hardware architecture of the CLI
+	// +optional
+	// +default="amd64"
+	arch string,
+) *File {
+	if operatingSystem == "" {
+		operatingSystem = "linux"
+	}
+	if arch == "" {
+		arch = "amd64"
+	}
+	baseURL := fmt.Sprintf("%s/%s", r.DownloadURL, r.Version)
+	basename := fmt.Sprintf("dagger_v%s_%s_%s", r.Version, operatingSystem, lookupArch(arch).release)
+	binName := "dagger"
+	unpack := []string{"tar", "-xzf", "/archive", "-C", "/unpack", binName}
+	if operatingSystem == "windows" {
+		binName += ".exe"
+		basename += ".zip"
+		unpack = []string{"unzip", "/archive", binName, "-d", "/unpack"}
+	} else {
+		basename += ".tar.gz"
+	}
+	archive := verifiedDownload(baseURL+"/"+basename, baseURL+"/checksums.txt", "")
+	return dag.Container().
+		From("alpine:"+alpineVersion).
+		WithMountedFile("/archive", archive).
+		WithDirectory("/unpack", dag.Directory()).
+		WithExec(unpack).
+		File("/unpack/" + binName)
+}
+
+// Pull the official worker image for this release, pinned by digest.
+//
+//	To pull from a mirror, set it with WithWorkerRegistry. Without a digest, the
+//	release tag is resolved on that registry when called: this is trust on first
+//	use, not a verification against published checksums. Pass the digest of the
+//	upstream image to make sure a mirror serves the exact same image.
+func (r *EngineRelease) WorkerImage(
+	ctx context.Context,
+	// Hardware architecture of the worker
+	// +optional
+	arch string,
+	// Digest of the image, eg. "sha256:...".
+	// Defaults to the digest of the release tag on the worker registry.
+	// +optional
+	digest string,
+) (*Container, error) {
+	var opts ContainerOpts
+	if arch != "" {
+		opts.Platform = lookupArch(arch).platform()
+	}
+	if digest == "" {
+		ref, err := dag.Container(opts).
+			From(fmt.Sprintf("%s:v%s", r.WorkerRegistry, r.Version)).
+			ImageRef(ctx)
+		if err != nil {
+			return nil, err
+		}
+		_, digest, _ = strings.Cut(ref, "@")
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		return nil, fmt.Errorf("invalid worker image digest %q", digest)
+	}
+	return dag.Container(opts).From(r.WorkerRegistry + "@" + digest), nil
+}
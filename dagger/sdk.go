@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+const (
+	golangciLintVersion = "v1.57"
+	pythonVersion       = "3.11"
+	nodeVersion         = "18"
+)
+
+// An SDK in the engine source tree
+type SDK struct {
+	// Name of the SDK: "go", "python" or "typescript"
+	Name   string
+	Engine *EngineSource
+}
+
+// An SDK in the engine source tree
+func (e *EngineSource) SDK(
+	// Name of the SDK: "go", "python" or "typescript"
+	name string,
+) (*SDK, error) {
+	switch name {
+	case "go", "python", "typescript":
+	default:
+		return nil, fmt.Errorf("unsupported SDK %q: must be go, python or typescript", name)
+	}
+	return &SDK{
+		Name:   name,
+		Engine: e,
+	}, nil
+}
+
+// Path of the SDK in the engine source tree, eg. "sdk/go"
+func (sdk *SDK) Path() string {
+	return path.Join("sdk", sdk.Name)
+}
+
+// Where the engine source is mounted in SDK containers
+func (sdk *SDK) workdir() string {
+	return path.Join("/app", sdk.Path())
+}
+
+// A container with the SDK source and its development dependencies
+func (sdk *SDK) Base() *Container {
+	var ctr *Container
+	switch sdk.Name {
+	case "go":
+		ctr = sdk.Engine.GoBase()
+	case "python":
+		ctr = dag.Container().
+			From("python:"+pythonVersion+"-slim").
+			WithMountedCache("/root/.cache/pip", dag.CacheVolume("pip")).
+			WithExec([]string{"pip", "install", "hatch"}).
+			WithMountedDirectory("/app", sdk.Engine.Source)
+	case "typescript":
+		ctr = dag.Container().
+			From("node:"+nodeVersion+"-alpine").
+			WithMountedCache("/usr/local/share/.cache/yarn", dag.CacheVolume("yarn")).
+			WithMountedDirectory("/app", sdk.Engine.Source).
+			WithWorkdir(sdk.workdir()).
+			WithExec([]string{"yarn", "install", "--frozen-lockfile"})
+	}
+	return ctr.WithWorkdir(sdk.workdir())
+}
+
+// Lint the SDK source code
+func (sdk *SDK) Lint(ctx context.Context) error {
+	var ctr *Container
+	switch sdk.Name {
+	case "go":
+		ctr = dag.Container().
+			From("golangci/golangci-lint:"+golangciLintVersion).
+			WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")).
+			WithMountedCache("/root/.cache/go-build", dag.CacheVolume("go-build")).
+			WithMountedDirectory("/app", sdk.Engine.Source).
+			WithWorkdir(sdk.workdir()).
+			WithExec([]string{"golangci-lint", "run", "-v", "--timeout", "5m"})
+	case "python":
+		ctr = sdk.Base().WithExec([]string{"hatch", "run", "lint"})
+	case "typescript":
+		ctr = sdk.Base().WithExec([]string{"yarn", "lint"})
+	}
+	_, err := ctr.Sync(ctx)
+	return err
+}
+
+// Run the SDK tests against a dev worker built from the same engine source
+func (sdk *SDK) Test(ctx context.Context) error {
+	var args []string
+	switch sdk.Name {
+	case "go":
+		args = []string{"go", "test", "-v", "./..."}
+	case "python":
+		args = []string{"hatch", "run", "test"}
+	case "typescript":
+		args = []string{"yarn", "test"}
+	}
+	ctr, err := sdk.withDevEngine()
+	if err != nil {
+		return err
+	}
+	_, err = ctr.WithExec(args).Sync(ctx)
+	return err
+}
+
+// Regenerate the SDK client bindings from the API of a dev worker,
+// and return the SDK directory.
+func (sdk *SDK) Generate() (*Directory, error) {
+	var args []string
+	switch sdk.Name {
+	case "go":
+		args = []string{"go", "generate", "-v", "./..."}
+	case "python":
+		args = []string{"hatch", "run", "dev:generate"}
+	case "typescript":
+		args = []string{"yarn", "gen"}
+	}
+	ctr, err := sdk.withDevEngine()
+	if err != nil {
+		return nil, err
+	}
+	return ctr.
+		// the code generators need a session with the engine
+		WithExec(append([]string{"dagger", "run"}, args...)).
+		Directory(sdk.workdir()), nil
+}
+
+// The SDK base container, wired to a dev worker
+func (sdk *SDK) withDevEngine() (*Container, error) {
+	return sdk.Engine.Worker().withClient(sdk.Base(), "dagger-dev-engine-sdk-"+sdk.Name)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A parsed semantic version, as used by engine release tags.
+// See https://semver.org
+type semver struct {
+	Major int
+	Minor int
+	Patch int
+	// Pre-release identifiers, eg. "rc.1". Empty for stable releases.
+	Pre string
+}
+
+// Parse a semantic version. A leading "v" is allowed.
+// Build metadata ("+...") is accepted and ignored.
+func parseSemver(s string) (semver, error) {
+	var v semver
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	str, _, _ = strings.Cut(str, "+")
+	str, pre, hasPre := strings.Cut(str, "-")
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("invalid version %q: empty pre-release", s)
+		}
+		v.Pre = pre
+	}
+	parts := strings.Split(str, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version %q: expected MAJOR.MINOR.PATCH", s)
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q: %q is not a number", s, part)
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// The version without pre-release identifiers
+func (v semver) release() semver {
+	return semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+}
+
+// Compare two versions following semver precedence rules.
+// Returns -1, 0 or 1.
+func (v semver) Compare(o semver) int {
+	for _, d := range [3]int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		// a stable release has precedence over its pre-releases
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			// numeric identifiers have lower precedence
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// Sort versions in ascending order
+func sortSemvers(versions []semver) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Compare(versions[j]) < 0
+	})
+}
+
+// A version constraint, eg. "^0.11", "~0.11.2" or ">=0.10 <0.12".
+// Alternatives can be separated by "||".
+type semverConstraint struct {
+	// Each alternative is a list of comparisons that must all match
+	alternatives [][]semverComparison
+}
+
+type semverComparison struct {
+	op string
+	v  semver
+}
+
+// Parse a version constraint. The empty string and "*" match any version.
+func parseSemverConstraint(s string) (*semverConstraint, error) {
+	c := new(semverConstraint)
+	alternatives := strings.Split(s, "||")
+	for _, alt := range alternatives {
+		// an empty alternative would match any version
+		if len(alternatives) > 1 && strings.TrimSpace(alt) == "" {
+			return nil, fmt.Errorf("invalid constraint %q: empty alternative", s)
+		}
+		var comparisons []semverComparison
+		for _, field := range splitConstraintFields(alt) {
+			cmps, err := parseSemverComparison(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+			comparisons = append(comparisons, cmps...)
+		}
+		c.alternatives = append(c.alternatives, comparisons)
+	}
+	return c, nil
+}
+
+// Split a constraint into fields, re-attaching operators separated from their
+// version by a space (eg. ">= 0.10").
+func splitConstraintFields(s string) []string {
+	var fields []string
+	for _, f := range strings.Fields(s) {
+		if n := len(fields); n > 0 && strings.Trim(fields[n-1], "<>=~^") == "" {
+			fields[n-1] += f
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func parseSemverComparison(s string) ([]semverComparison, error) {
+	if s == "*" || s == "x" || s == "X" {
+		return nil, nil
+	}
+	var op string
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, n, err := parsePartialSemver(strings.TrimPrefix(s, op))
+	if err != nil {
+		return nil, err
+	}
+	// Upper bound of a partial version, eg. 0.11 -> <0.12.0
+	next := func() semver {
+		switch n {
+		case 0:
+			return semver{}
+		case 1:
+			return semver{Major: v.Major + 1}
+		case 2:
+			return semver{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+	switch op {
+	case "", "=":
+		if n == 3 {
+			return []semverComparison{{"=", v}}, nil
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return []semverComparison{{">=", v}, {"<", next()}}, nil
+	case ">=", "<":
+		return []semverComparison{{op, v}}, nil
+	case ">":
+		if n < 3 && n > 0 {
+			return []semverComparison{{">=", next()}}, nil
+		}
+		return []semverComparison{{op, v}}, nil
+	case "<=":
+		if n < 3 && n > 0 {
+			return []semverComparison{{"<", next()}}, nil
+		}
+		return []semverComparison{{op, v}}, nil
+	case "~":
+		// ~1.2.3 := >=1.2.3 <1.3.0, ~1 := >=1.0.0 <2.0.0
+		if n == 3 {
+			n = 2
+		}
+		return []semverComparison{{">=", v}, {"<", next()}}, nil
+	case "^":
+		// ^1.2.3 := >=1.2.3 <2.0.0, ^0.11 := >=0.11.0 <0.12.0, ^0.0.3 := =0.0.3
+		var upper semver
+		switch {
+		case v.Major > 0 || n == 1:
+			upper = semver{Major: v.Major + 1}
+		case v.Minor > 0 || n == 2:
+			upper = semver{Minor: v.Minor + 1}
+		default:
+			upper = semver{Patch: v.Patch + 1}
+		}
+		return []semverComparison{{">=", v}, {"<", upper}}, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %q", op)
+}
+
+// Parse a possibly partial version ("1", "1.2", "1.2.x", "1.2.3-rc.1").
+// Returns the version and the number of components that were specified.
+func parsePartialSemver(s string) (semver, int, error) {
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	core, _, hasPre := strings.Cut(core, "-")
+	if strings.Count(core, ".") == 2 && !strings.ContainsAny(core, "xX*") {
+		v, err := parseSemver(s)
+		return v, 3, err
+	}
+	var (
+		v    semver
+		nums []int
+	)
+	if hasPre {
+		return v, 0, fmt.Errorf("invalid version %q: a pre-release requires MAJOR.MINOR.PATCH", s)
+	}
+	for _, part := range strings.Split(core, ".") {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, 0, fmt.Errorf("invalid version %q", s)
+		}
+		nums = append(nums, n)
+	}
+	if len(nums) > 3 {
+		return v, 0, fmt.Errorf("invalid version %q", s)
+	}
+	for i, n := range nums {
+		switch i {
+		case 0:
+			v.Major = n
+		case 1:
+			v.Minor = n
+		case 2:
+			v.Patch = n
+		}
+	}
+	return v, len(nums), nil
+}
+
+// Check whether a version satisfies the constraint
+func (c *semverConstraint) Check(v semver) bool {
+	for _, alt := range c.alternatives {
+		if checkAll(alt, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkAll(comparisons []semverComparison, v semver) bool {
+	for _, cmp := range comparisons {
+		c := v.Compare(cmp.v)
+		switch cmp.op {
+		case "=":
+			if c != 0 {
+				return false
+			}
+		case ">":
+			if c <= 0 {
+				return false
+			}
+		case ">=":
+			if c < 0 {
+				return false
+			}
+		case "<":
+			if c >= 0 {
+				return false
+			}
+			// "<0.12.0" excludes the pre-releases of 0.12.0
+			if v.Pre != "" && cmp.v.Pre == "" && v.release() == cmp.v.release() {
+				return false
+			}
+		case "<=":
+			if c > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package main
+
+import "testing"
+
+func TestSemverConstraint(t *testing.T) {
+	for _, tc := range []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{
+			constraint: "",
+			match:      []string{"0.1.0", "0.11.9", "1.2.3"},
+		},
+		{
+			constraint: "*",
+			match:      []string{"0.1.0", "1.2.3"},
+		},
+		{
+			constraint: "0.11.9",
+			match:      []string{"0.11.9", "v0.11.9"},
+			noMatch:    []string{"0.11.8", "0.11.10", "0.11.9-rc.1"},
+		},
+		{
+			constraint: "0.11",
+			match:      []string{"0.11.0", "0.11.9"},
+			noMatch:    []string{"0.10.5", "0.12.0"},
+		},
+		{
+			constraint: "0.9.x",
+			match:      []string{"0.9.0", "0.9.10"},
+			noMatch:    []string{"0.8.9", "0.10.0"},
+		},
+		{
+			constraint: "^0.11",
+			match:      []string{"0.11.0", "0.11.9"},
+			noMatch:    []string{"0.10.5", "0.12.0", "1.0.0"},
+		},
+		{
+			constraint: "^1.2.3",
+			match:      []string{"1.2.3", "1.9.0"},
+			noMatch:    []string{"1.2.2", "2.0.0"},
+		},
+		{
+			constraint: "^0.0.3",
+			match:      []string{"0.0.3"},
+			noMatch:    []string{"0.0.4", "0.1.0"},
+		},
+		{
+			constraint: "~0.11.2",
+			match:      []string{"0.11.2", "0.11.9"},
+			noMatch:    []string{"0.11.1", "0.12.0"},
+		},
+		{
+			constraint: ">=0.10 <0.12",
+			match:      []string{"0.10.0", "0.11.9"},
+			noMatch:    []string{"0.9.9", "0.12.0", "0.12.0-rc.1"},
+		},
+		{
+			constraint: ">= 0.10 <= 0.11",
+			match:      []string{"0.10.0", "0.11.9"},
+			noMatch:    []string{"0.9.9", "0.12.0"},
+		},
+		{
+			constraint: ">0.11 <=0.12.1",
+			match:      []string{"0.12.0", "0.12.1"},
+			noMatch:    []string{"0.11.9", "0.12.2"},
+		},
+		{
+			constraint: ">=0.12.0-rc.1",
+			match:      []string{"0.12.0-rc.1", "0.12.0-rc.2", "0.12.0", "1.0.0"},
+			noMatch:    []string{"0.12.0-beta.1", "0.11.9"},
+		},
+		{
+			constraint: "^0.11.0-rc.1",
+			match:      []string{"0.11.0-rc.1", "0.11.0", "0.11.9"},
+			noMatch:    []string{"0.11.0-beta.1", "0.10.9", "0.12.0-rc.1", "0.12.0"},
+		},
+		{
+			constraint: "0.11.0+build.5",
+			match:      []string{"0.11.0"},
+			noMatch:    []string{"0.11.1"},
+		},
+		{
+			constraint: "0.9.x || ^0.11",
+			match:      []string{"0.9.3", "0.11.9"},
+			noMatch:    []string{"0.10.0", "0.12.0"},
+		},
+	} {
+		c, err := parseSemverConstraint(tc.constraint)
+		if err != nil {
+			t.Errorf("parseSemverConstraint(%q): %v", tc.constraint, err)
+			continue
+		}
+		for _, s := range tc.match {
+			if !c.Check(mustParseSemver(t, s)) {
+				t.Errorf("%q should match %s", tc.constraint, s)
+			}
+		}
+		for _, s := range tc.noMatch {
+			if c.Check(mustParseSemver(t, s)) {
+				t.Errorf("%q should not match %s", tc.constraint, s)
+			}
+		}
+	}
+}
+
+func TestSemverConstraintInvalid(t *testing.T) {
+	for _, constraint := range []string{
+		"0.9.x ||",
+		"|| ^0.11",
+		"0.9.x || || ^0.11",
+		"||",
+		"foo",
+		">=0.a",
+		"1.2.3.4",
+		"0.11-rc.1",
+		">=0.12.0-",
+	} {
+		if _, err := parseSemverConstraint(constraint); err == nil {
+			t.Errorf("parseSemverConstraint(%q) should fail", constraint)
+		}
+	}
+}
+
+func mustParseSemver(t *testing.T, s string) semver {
+	t.Helper()
+	v, err := parseSemver(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
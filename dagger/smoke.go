@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	smokeLogPath    = "/tmp/smoke.log"
+	smokeOutputPath = "/tmp/smoke.out"
+)
+
+// The results of smoke testing a worker
+type SmokeResult struct {
+	// One entry per check, in the order they ran
+	Checks []*SmokeCheck
+}
+
+// The result of one smoke check
+type SmokeCheck struct {
+	Name   string
+	Passed bool
+	// How long the check took, eg. "2.1s". The first check includes booting the worker.
+	Duration string
+	// Output of the CLI, when the check failed
+	Error string
+}
+
+// Whether all checks passed
+func (r *SmokeResult) Passed() bool {
+	for _, check := range r.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Return an error if any check failed
+func (r *SmokeResult) Check() error {
+	var failed []string
+	for _, check := range r.Checks {
+		if !check.Passed {
+			failed = append(failed, check.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d smoke checks failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// A smoke check: queries sent with the CLI, by a shell script checking their results.
+//
+//	The script gets the paths of the query files as arguments, and the output of the
+//	last query is teed to smokeOutputPath.
+type smokeQuery struct {
+	name    string
+	queries []string
+	script  string
+}
+
+var smokeQueries = []smokeQuery{
+	{
+		name:    "boot",
+		queries: []string{`{ defaultPlatform }`},
+		script:  `dagger query --doc "$1" | tee ` + smokeOutputPath + ` && grep -q linux ` + smokeOutputPath,
+	},
+	{
+		name:    "container-from",
+		queries: []string{`{ container { from(address: "alpine:` + alpineVersion + `") { file(path: "/etc/alpine-release") { contents } } } }`},
+		script:  `dagger query --doc "$1" | tee ` + smokeOutputPath + ` && grep -q '` + alpineVersion + `' ` + smokeOutputPath,
+	},
+	{
+		name:    "exec",
+		queries: []string{`{ container { from(address: "alpine:` + alpineVersion + `") { withExec(args: ["sh", "-c", "echo smoke-$((6 * 7))"]) { stdout } } } }`},
+		script:  `dagger query --doc "$1" | tee ` + smokeOutputPath + ` && grep -q smoke-42 ` + smokeOutputPath,
+	},
+	{
+		name:    "directory-export",
+		queries: []string{`{ directory { withNewFile(path: "hello.txt", contents: "smoke") { export(path: "/tmp/smoke-export") } } }`},
+		script:  `dagger query --doc "$1" && test "$(cat /tmp/smoke-export/hello.txt)" = smoke`,
+	},
+	{
+		name: "service-binding",
+		queries: []string{
+			`{
+				container { from(address: "alpine:` + alpineVersion + `") {
+					# alpine's busybox has no httpd applet
+					withExec(args: ["apk", "add", "--no-cache", "busybox-extras"]) {
+						withNewFile(path: "/srv/index.html", contents: "smoke") {
+							withExposedPort(port: 8080) {
+								withExec(args: ["busybox-extras", "httpd", "-f", "-p", "8080", "-h", "/srv"]) { asService { id } }
+							}
+						}
+					}
+				} }
+			}`,
+			`query($svc: ServiceID!) {
+				container { from(address: "alpine:` + alpineVersion + `") {
+					withServiceBinding(alias: "www", service: $svc) {
+						withExec(args: ["wget", "-qO-", "http://www:8080/index.html"]) { stdout }
+					}
+				} }
+			}`,
+		},
+		script: `svc=$(dagger query --doc "$1" | jq -r '.. | .id? // empty') &&
+			dagger query --doc "$2" --var svc="$svc" | tee ` + smokeOutputPath + ` &&
+			grep -q smoke ` + smokeOutputPath,
+	},
+}
+
+// Boot the worker, and run a few core queries against it with a matching CLI.
+//
+//	This is a quick check that a worker works, before running the full test suite
+//	or publishing it. Failed checks don't cause an error: call `check` on the result for that.
+func (w *Worker) Smoke(
+	ctx context.Context,
+	// Name of the cache volume holding the worker state
+	// +optional
+	// +default="dagger-dev-engine-smoke"
+	stateVolume string,
+) (*SmokeResult, error) {
+	if stateVolume == "" {
+		stateVolume = "dagger-dev-engine-smoke"
+	}
+	base := dag.Container().
+		From("alpine:" + alpineVersion).
+		WithExec([]string{"apk", "add", "--no-cache", "jq"})
+	client, err := w.withClient(base, stateVolume)
+	if err != nil {
+		return nil, err
+	}
+	// always run the checks against a fresh session
+	client = withCacheBuster(client)
+	result := &SmokeResult{}
+	for _, q := range smokeQueries {
+		check, err := smoke(ctx, client, q)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.name, err)
+		}
+		result.Checks = append(result.Checks, check)
+	}
+	return result, nil
+}
+
+// Run one smoke check
+func smoke(ctx context.Context, client *Container, q smokeQuery) (*SmokeCheck, error) {
+	args := []string{"sh", "-c", "set -o pipefail; " + q.script, q.name}
+	for i, query := range q.queries {
+		path := fmt.Sprintf("/tmp/smoke-%s-%d.graphql", q.name, i)
+		client = client.WithNewFile(path, ContainerWithNewFileOpts{Contents: query})
+		args = append(args, path)
+	}
+	start := time.Now()
+	ctr, exitCode, err := execWithExitCode(ctx, client, args, smokeLogPath)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Since(start)
+	check := &SmokeCheck{
+		Name:     q.name,
+		Passed:   exitCode == 0,
+		Duration: duration.Round(100 * time.Millisecond).String(),
+	}
+	if !check.Passed {
+		log, err := ctr.File(smokeLogPath).Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		check.Error = log
+	}
+	return check, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otelCollectorImage    = "otel/opentelemetry-collector-contrib:0.102.1"
+	otelCollectorHostname = "otel-collector"
+	otelSpansPath         = "/spans/spans.json"
+)
+
+// Export OpenTelemetry traces from the engine to an OTLP collector
+func (c *Cloud) Telemetry(
+	// OTLP endpoint, eg. "https://otlp.example.com:4318"
+	endpoint string,
+	// Headers to send with each export, eg. credentials, as "key1=value1,key2=value2"
+	// +optional
+	headers *Secret,
+	// OTLP protocol: "grpc" or "http/protobuf"
+	// +optional
+	// +default="http/protobuf"
+	protocol string,
+	// A service providing the endpoint, eg. a local collector
+	// +optional
+	service *Service,
+) (*Telemetry, error) {
+	if protocol == "" {
+		protocol = "http/protobuf"
+	}
+	if protocol != "grpc" && protocol != "http/protobuf" {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q: must be grpc or http/protobuf", protocol)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	t := &Telemetry{
+		Endpoint: endpoint,
+		Headers:  headers,
+		Protocol: protocol,
+	}
+	if service != nil {
+		t.Service, t.ServiceHost = service, u.Hostname()
+	}
+	return t, nil
+}
+
+// Configuration for exporting OpenTelemetry traces
+type Telemetry struct {
+	Endpoint string
+	// Headers sent with each export, as "key1=value1,key2=value2"
+	Headers  *Secret
+	Protocol string
+	// +private
+	Service *Service
+	// +private
+	ServiceHost string
+}
+
+// Configure a container to export traces, eg. a worker or a Dagger CLI
+func (t *Telemetry) Install(ctr *Container) *Container {
+	ctr = ctr.
+		WithEnvVariable("OTEL_EXPORTER_OTLP_ENDPOINT", t.Endpoint).
+		WithEnvVariable("OTEL_EXPORTER_OTLP_PROTOCOL", t.Protocol)
+	if t.Headers != nil {
+		// a secret, so credentials don't end up in image configs or logs
+		ctr = ctr.WithSecretVariable("OTEL_EXPORTER_OTLP_HEADERS", t.Headers)
+	}
+	if t.Service != nil {
+		ctr = ctr.WithServiceBinding(t.ServiceHost, t.Service)
+	}
+	return ctr
+}
+
+// Export traces from the worker, and from the CLIs wired to it
+func (w *Worker) WithTelemetry(telemetry *Telemetry) *Worker {
+	w.Telemetry = telemetry
+	return w
+}
+
+// A local OpenTelemetry collector, which records the spans it receives
+func (c *Cloud) Collector() *Collector {
+	return &Collector{
+		// spans are stored in a cache volume, so they survive the collector service
+		Volume: fmt.Sprintf("otel-collector-spans-%d", time.Now().UnixNano()),
+	}
+}
+
+// A local OpenTelemetry collector
+type Collector struct {
+	// The cache volume where received spans are stored
+	Volume string
+}
+
+const otelCollectorConfig = `receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:4317
+      http:
+        endpoint: 0.0.0.0:4318
+exporters:
+  file:
+    path: ` + otelSpansPath + `
+    flush_interval: 1s
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      exporters: [file]
+`
+
+// The collector service, listening for OTLP on ports 4317 (grpc) and 4318 (http)
+func (c *Collector) Service() *Service {
+	return dag.Container().
+		From(otelCollectorImage).
+		WithNewFile("/etc/otelcol/config.yaml", ContainerWithNewFileOpts{Contents: otelCollectorConfig}).
+		WithMountedCache("/spans", dag.CacheVolume(c.Volume), ContainerWithMountedCacheOpts{
+			Owner: "10001",
+		}).
+		WithExposedPort(4317, ContainerWithExposedPortOpts{Protocol: Tcp}).
+		WithExposedPort(4318, ContainerWithExposedPortOpts{Protocol: Tcp}).
+		WithExec([]string{"--config", "/etc/otelcol/config.yaml"}).
+		AsService()
+}
+
+// Telemetry configuration exporting to this collector
+func (c *Collector) Telemetry() *Telemetry {
+	return &Telemetry{
+		Endpoint:    fmt.Sprintf("http://%s:4318", otelCollectorHostname),
+		Protocol:    "http/protobuf",
+		Service:     c.Service(),
+		ServiceHost: otelCollectorHostname,
+	}
+}
+
+// The spans received so far, in the OTLP JSON format: one export request per line
+func (c *Collector) Raw(ctx context.Context) (string, error) {
+	ctr := dag.Container().
+		From("alpine:"+alpineVersion).
+		WithMountedCache("/spans", dag.CacheVolume(c.Volume))
+	// the volume contents change: don't cache the result
+	return withCacheBuster(ctr).
+		WithExec([]string{"sh", "-c", "cat " + otelSpansPath + " 2>/dev/null || true"}).
+		Stdout(ctx)
+}
+
+// A span received by the collector
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	// The service that emitted the span, from the service.name resource attribute
+	Service string
+	// Start time, in RFC3339 format
+	Start string
+	// Duration of the span, eg. "1.2s"
+	Duration string
+	// Status code: "unset", "ok" or "error"
+	Status string
+}
+
+// The spans received so far
+func (c *Collector) Spans(ctx context.Context) ([]*Span, error) {
+	raw, err := c.Raw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseOTLPSpans(raw)
+}
+
+// OTLP JSON encoding of traces.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpTraces struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string `json:"key"`
+				Value struct {
+					StringValue string `json:"stringValue"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID           string `json:"traceId"`
+				SpanID            string `json:"spanId"`
+				ParentSpanID      string `json:"parentSpanId"`
+				Name              string `json:"name"`
+				StartTimeUnixNano string `json:"startTimeUnixNano"`
+				EndTimeUnixNano   string `json:"endTimeUnixNano"`
+				Status            struct {
+					Code int `json:"code"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+var otlpStatusCodes = []string{"unset", "ok", "error"}
+
+func parseOTLPSpans(raw string) ([]*Span, error) {
+	var spans []*Span
+	scanner := bufio.NewScanner(strings.NewReader(raw))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var traces otlpTraces
+		if err := json.Unmarshal([]byte(line), &traces); err != nil {
+			return nil, fmt.Errorf("invalid OTLP export: %w", err)
+		}
+		for _, rs := range traces.ResourceSpans {
+			var service string
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					service = attr.Value.StringValue
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					start, _ := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+					end, _ := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+					status := "unset"
+					if s.Status.Code >= 0 && s.Status.Code < len(otlpStatusCodes) {
+						status = otlpStatusCodes[s.Status.Code]
+					}
+					spans = append(spans, &Span{
+						TraceID:      s.TraceID,
+						SpanID:       s.SpanID,
+						ParentSpanID: s.ParentSpanID,
+						Name:         s.Name,
+						Service:      service,
+						Start:        time.Unix(0, start).UTC().Format(time.RFC3339Nano),
+						Duration:     time.Duration(end - start).String(),
+						Status:       status,
+					})
+				}
+			}
+		}
+	}
+	return spans, scanner.Err()
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	testPass = "pass"
+	testFail = "fail"
+	testSkip = "skip"
+)
+
+// The results of a test run, parsed from the JSON output of `go test`
+type TestReport struct {
+	// The raw JSON log, as written by gotestsum
+	Log *File
+	// Exit code of the test command
+	ExitCode int
+	// Results of each tested package
+	Packages []*PackageResult
+	// Results of each individual test, including subtests
+	Tests []*TestResult
+}
+
+// The result of testing a Go package
+type PackageResult struct {
+	// Import path of the package
+	Name string
+	// One of "pass", "fail" or "skip"
+	Status string
+	// How long it took to test the package, eg. "12.3s"
+	Duration string
+	// Package-level output. Only included if the package failed.
+	Output string
+}
+
+// The result of a single Go test
+type TestResult struct {
+	// Import path of the package containing the test
+	Package string
+	// Name of the test, eg. "TestContainerFrom/alpine"
+	Name string
+	// One of "pass", "fail" or "skip"
+	Status string
+	// How long the test took, eg. "1.2s"
+	Duration string
+	// Output of the test. Only included if the test failed.
+	Output string
+}
+
+// Whether all tests passed
+func (r *TestReport) Passed() bool {
+	if r.ExitCode != 0 {
+		return false
+	}
+	for _, pkg := range r.Packages {
+		if pkg.Status == testFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Return an error if any test failed
+func (r *TestReport) Check() error {
+	if r.Passed() {
+		return nil
+	}
+	failed := r.FailedTests()
+	names := make([]string, 0, len(failed))
+	for _, t := range failed {
+		names = append(names, t.Package+"."+t.Name)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("tests failed with exit code %d", r.ExitCode)
+	}
+	return fmt.Errorf("%d tests failed: %s", len(names), strings.Join(names, ", "))
+}
+
+// Tests that failed
+func (r *TestReport) FailedTests() []*TestResult {
+	return r.testsWithStatus(testFail)
+}
+
+// Tests that were skipped
+func (r *TestReport) SkippedTests() []*TestResult {
+	return r.testsWithStatus(testSkip)
+}
+
+// Tests that passed
+func (r *TestReport) PassedTests() []*TestResult {
+	return r.testsWithStatus(testPass)
+}
+
+// Packages that failed
+func (r *TestReport) FailedPackages() []*PackageResult {
+	var pkgs []*PackageResult
+	for _, pkg := range r.Packages {
+		if pkg.Status == testFail {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
+func (r *TestReport) testsWithStatus(status string) []*TestResult {
+	var tests []*TestResult
+	for _, t := range r.Tests {
+		if t.Status == status {
+			tests = append(tests, t)
+		}
+	}
+	return tests
+}
+
+// A one-line summary of the test run
+func (r *TestReport) Summary() string {
+	return fmt.Sprintf("%d tests, %d passed, %d failed, %d skipped, in %d packages",
+		len(r.Tests),
+		len(r.PassedTests()),
+		len(r.FailedTests()),
+		len(r.SkippedTests()),
+		len(r.Packages),
+	)
+}
+
+// Export the report in JUnit XML format
+func (r *TestReport) JUnit() (*File, error) {
+	contents, err := r.junit()
+	if err != nil {
+		return nil, err
+	}
+	return dag.Directory().WithNewFile("junit.xml", contents).File("junit.xml"), nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+	// Package-level output, for failures outside of any test (build errors, panics in init...)
+	SystemOut string `xml:"system-out,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+func (r *TestReport) junit() (string, error) {
+	suites := junitTestSuites{}
+	byPackage := make(map[string][]*TestResult)
+	for _, t := range r.Tests {
+		byPackage[t.Package] = append(byPackage[t.Package], t)
+	}
+	for _, pkg := range r.Packages {
+		suite := junitTestSuite{
+			Name:      pkg.Name,
+			Time:      junitSeconds(pkg.Duration),
+			SystemOut: pkg.Output,
+		}
+		for _, t := range byPackage[pkg.Name] {
+			tc := junitTestCase{
+				Name:      t.Name,
+				ClassName: t.Package,
+				Time:      junitSeconds(t.Duration),
+			}
+			switch t.Status {
+			case testFail:
+				tc.Failure = &junitMessage{Message: "Failed", Contents: t.Output}
+				suite.Failures++
+			case testSkip:
+				tc.Skipped = &junitMessage{Message: "Skipped"}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, tc)
+			suite.Tests++
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+	out, err := xml.MarshalIndent(suites, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(out) + "\n", nil
+}
+
+// Convert a duration string to fractional seconds, as expected by JUnit
+func junitSeconds(duration string) string {
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return "0"
+	}
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// An event emitted by `go test -json`.
+// See https://pkg.go.dev/cmd/test2json
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// Parse the JSON output of `go test` into a test report
+func parseTestReport(log string) (*TestReport, error) {
+	var (
+		report   = new(TestReport)
+		packages = make(map[string]*PackageResult)
+		tests    = make(map[string]*TestResult)
+		outputs  = make(map[string]*strings.Builder)
+	)
+	output := func(key string) *strings.Builder {
+		if outputs[key] == nil {
+			outputs[key] = new(strings.Builder)
+		}
+		return outputs[key]
+	}
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	// test output lines can be very long
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var ev testEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			return nil, fmt.Errorf("invalid test event %q: %w", line, err)
+		}
+		if ev.Package == "" {
+			continue
+		}
+		pkg, ok := packages[ev.Package]
+		if !ok {
+			pkg = &PackageResult{Name: ev.Package}
+			packages[ev.Package] = pkg
+			report.Packages = append(report.Packages, pkg)
+		}
+		key := ev.Package
+		if ev.Test != "" {
+			key += "\x00" + ev.Test
+		}
+		if ev.Test != "" && tests[key] == nil {
+			t := &TestResult{Package: ev.Package, Name: ev.Test}
+			tests[key] = t
+			report.Tests = append(report.Tests, t)
+		}
+		switch ev.Action {
+		case "output":
+			output(key).WriteString(ev.Output)
+		case testPass, testFail, testSkip:
+			duration := (time.Duration(ev.Elapsed * float64(time.Second))).String()
+			if ev.Test == "" {
+				pkg.Status = ev.Action
+				pkg.Duration = duration
+				continue
+			}
+			t := tests[key]
+			t.Status = ev.Action
+			t.Duration = duration
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Tests and packages that never completed (eg. after a timeout or a panic)
+	// are reported as failed
+	for key, t := range tests {
+		if t.Status == "" {
+			t.Status = testFail
+		}
+		if t.Status == testFail && outputs[key] != nil {
+			t.Output = outputs[key].String()
+		}
+	}
+	for key, pkg := range packages {
+		if pkg.Status == "" {
+			pkg.Status = testFail
+		}
+		if pkg.Status == testFail && outputs[key] != nil {
+			pkg.Output = outputs[key].String()
+		}
+	}
+	sort.SliceStable(report.Packages, func(i, j int) bool {
+		return report.Packages[i].Name < report.Packages[j].Name
+	})
+	return report, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTestReport(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		log      string
+		packages map[string]string
+		tests    map[string]string
+		outputs  map[string]string
+	}{
+		{
+			name: "pass, fail and skip",
+			log: `{"Action":"run","Package":"a","Test":"TestPass"}
+{"Action":"output","Package":"a","Test":"TestPass","Output":"ok\n"}
+{"Action":"pass","Package":"a","Test":"TestPass","Elapsed":0.5}
+{"Action":"run","Package":"a","Test":"TestFail"}
+{"Action":"output","Package":"a","Test":"TestFail","Output":"boom\n"}
+{"Action":"fail","Package":"a","Test":"TestFail","Elapsed":1}
+{"Action":"skip","Package":"a","Test":"TestSkip"}
+{"Action":"fail","Package":"a","Elapsed":1.5}`,
+			packages: map[string]string{"a": testFail},
+			tests:    map[string]string{"TestPass": testPass, "TestFail": testFail, "TestSkip": testSkip},
+			outputs:  map[string]string{"TestFail": "boom\n", "TestPass": ""},
+		},
+		{
+			name: "subtests",
+			log: `{"Action":"run","Package":"a","Test":"TestParent"}
+{"Action":"run","Package":"a","Test":"TestParent/child"}
+{"Action":"pass","Package":"a","Test":"TestParent/child"}
+{"Action":"pass","Package":"a","Test":"TestParent"}
+{"Action":"pass","Package":"a"}`,
+			packages: map[string]string{"a": testPass},
+			tests:    map[string]string{"TestParent": testPass, "TestParent/child": testPass},
+		},
+		{
+			name: "unfinished test and package",
+			log: `{"Action":"run","Package":"a","Test":"TestHang"}
+{"Action":"output","Package":"a","Test":"TestHang","Output":"waiting\n"}
+{"Action":"output","Package":"a","Output":"panic: test timed out\n"}`,
+			packages: map[string]string{"a": testFail},
+			tests:    map[string]string{"TestHang": testFail},
+			outputs:  map[string]string{"TestHang": "waiting\n", "": "panic: test timed out\n"},
+		},
+		{
+			name: "build failure",
+			log: `not json
+{"Action":"output","Package":"b","Output":"# b\nb.go:1: syntax error\n"}
+{"Action":"fail","Package":"b"}
+{"Action":"skip","Package":"c"}`,
+			packages: map[string]string{"b": testFail, "c": testSkip},
+			outputs:  map[string]string{"": "# b\nb.go:1: syntax error\n"},
+		},
+	} {
+		report, err := parseTestReport(tc.log)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(report.Packages) != len(tc.packages) {
+			t.Errorf("%s: got %d packages, want %d", tc.name, len(report.Packages), len(tc.packages))
+		}
+		for _, pkg := range report.Packages {
+			if want := tc.packages[pkg.Name]; pkg.Status != want {
+				t.Errorf("%s: package %s is %q, want %q", tc.name, pkg.Name, pkg.Status, want)
+			}
+			if want, ok := tc.outputs[""]; ok && pkg.Status == testFail && pkg.Output != want {
+				t.Errorf("%s: package %s output %q, want %q", tc.name, pkg.Name, pkg.Output, want)
+			}
+		}
+		if len(report.Tests) != len(tc.tests) {
+			t.Errorf("%s: got %d tests, want %d", tc.name, len(report.Tests), len(tc.tests))
+		}
+		for _, test := range report.Tests {
+			if want := tc.tests[test.Name]; test.Status != want {
+				t.Errorf("%s: test %s is %q, want %q", tc.name, test.Name, test.Status, want)
+			}
+			if want, ok := tc.outputs[test.Name]; ok && test.Output != want {
+				t.Errorf("%s: test %s output %q, want %q", tc.name, test.Name, test.Output, want)
+			}
+		}
+	}
+}
+
+func TestParseTestReportInvalid(t *testing.T) {
+	if _, err := parseTestReport(`{"Action":`); err == nil {
+		t.Error("parseTestReport should fail on a truncated event")
+	}
+}
+
+func TestJUnit(t *testing.T) {
+	report := &TestReport{
+		Packages: []*PackageResult{
+			{Name: "a", Status: testFail, Duration: "1.5s"},
+			{Name: "b", Status: testFail, Output: "b.go:1: syntax error"},
+		},
+		Tests: []*TestResult{
+			{Package: "a", Name: "TestPass", Status: testPass, Duration: "500ms"},
+			{Package: "a", Name: "TestFail", Status: testFail, Duration: "1s", Output: "boom <&>"},
+			{Package: "a", Name: "TestSkip", Status: testSkip, Duration: "0s"},
+		},
+	}
+	out, err := report.junit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<testsuites tests="3" failures="1" skipped="1">`,
+		`<testsuite name="a" tests="3" failures="1" skipped="1" time="1.500">`,
+		`<testcase name="TestPass" classname="a" time="0.500"></testcase>`,
+		`<failure message="Failed">boom &lt;&amp;&gt;</failure>`,
+		`<skipped message="Skipped"></skipped>`,
+		`<testsuite name="b" tests="0" failures="0" skipped="0" time="0">`,
+		`<system-out>b.go:1: syntax error</system-out>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("junit output is missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const execExitCodePath = "/tmp/.exit-code"
+
 type WorkerOpts struct {
 	Version               string
 	TraceLogs             bool
@@ -37,35 +43,28 @@ fi
 func devWorkerEntrypoint() string {
 	builder := strings.Builder{}
 	builder.WriteString(baseWorkerEntrypoint())
-	builder.WriteString(`--network-name dagger-devenv --network-cidr 10.89.0.0/16 "$@"` + "\n")
+	builder.WriteString(`--network-name dagger-devenv --network-cidr ` + devWorkerNetworkCIDR + ` "$@"` + "\n")
 	return builder.String()
 }
 
-func baseWorkerConfig() string {
-	builder := strings.Builder{}
-	builder.WriteString("debug = true\n")
-	builder.WriteString(fmt.Sprintf("root = %q\n", workerDefaultStateDir))
-	builder.WriteString(`insecure-entitlements = ["security.insecure"]` + "\n")
-	return builder.String()
+func baseWorkerConfig() *WorkerConfig {
+	return &WorkerConfig{
+		Debug:        true,
+		Root:         workerDefaultStateDir,
+		Entitlements: []string{"security.insecure"},
+		GRPCAddresses: []string{
+			"unix://" + workerDefaultSockPath,
+			fmt.Sprintf("tcp://0.0.0.0:%d", devWorkerListenPort),
+		},
+	}
 }
 
-func devWorkerConfig() string {
-	builder := strings.Builder{}
-	builder.WriteString(baseWorkerConfig())
-
-	builder.WriteString("[grpc]\n")
-	builder.WriteString(fmt.Sprintf("\taddress=[\"unix://%s\", \"tcp://0.0.0.0:%d\"]\n", workerDefaultSockPath, devWorkerListenPort))
-
-	builder.WriteString("[registry.\"docker.io\"]\n")
-	builder.WriteString("\tmirrors = [\"mirror.gcr.io\"]\n")
-
-	builder.WriteString("[registry.\"registry:5000\"]\n")
-	builder.WriteString("\thttp = true\n")
-
-	builder.WriteString("[registry.\"privateregistry:5000\"]\n")
-	builder.WriteString("\thttp = true\n")
-
-	return builder.String()
+func devWorkerConfig() *WorkerConfig {
+	return baseWorkerConfig().
+		WithRegistryMirror("docker.io", "mirror.gcr.io").
+		// registries used by the engine integration tests
+		WithHTTPRegistry("registry:5000").
+		WithHTTPRegistry("privateregistry:5000")
 }
 
 func registry() *Service {
@@ -86,3 +85,57 @@ func privateRegistry() *Service {
 		WithExec(nil).
 		AsService()
 }
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Run a command without failing on a non-zero exit, and return its exit code.
+// If logPath is set, the combined stdout and stderr of the command are written there.
+// For test runs and checks, a report built from the log is more useful than an error.
+func execWithExitCode(ctx context.Context, ctr *Container, args []string, logPath string) (*Container, int, error) {
+	script := `"$@"`
+	if logPath != "" {
+		script += " > " + shellQuote(logPath) + " 2>&1"
+	}
+	script += "; echo -n $? > " + execExitCodePath
+	ctr, err := ctr.
+		WithExec(append([]string{"sh", "-c", script, args[0]}, args...)).
+		Sync(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	code, err := ctr.File(execExitCodePath).Contents(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(code))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid exit code %q: %w", code, err)
+	}
+	return ctr, exitCode, nil
+}
+
+// Make sure the next commands in a container run again, rather than being cached
+func withCacheBuster(ctr *Container) *Container {
+	return ctr.WithEnvVariable("CACHEBUSTER", strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// Quote a string for use as a single word in a shell script
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
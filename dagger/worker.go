@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -11,43 +14,78 @@ const (
 	workerBinName = "dagger-engine"
 	shimBinName   = "dagger-shim"
 	daggerBinName = "dagger"
+	daggerCLIPath = "/usr/local/bin/" + daggerBinName
 	goVersion     = "1.20.6"
-	runcVersion   = "v1.1.5"
-	cniVersion    = "v1.2.0"
-	qemuBinImage  = "tonistiigi/binfmt:buildkit-v7.1.0-30" // nolint:gosec
+
+	// Default versions of the worker components
+	runcVersion  = "v1.1.5"
+	cniVersion   = "v1.2.0"
+	qemuBinImage = "tonistiigi/binfmt:buildkit-v7.1.0-30" // nolint:gosec
 
 	workerDefaultStateDir = "/var/lib/dagger"
 	workerTomlPath        = "/etc/dagger/engine.toml"
 	workerEntrypointPath  = "/usr/local/bin/dagger-entrypoint.sh"
 	workerDefaultSockPath = "/var/run/buildkit/buildkitd.sock"
 	devWorkerListenPort   = 1234
+
+	testLogPath = "/app/tests.log"
 )
 
 type Worker struct {
 	GoBase  *Container
 	Engine  *EngineSource
 	Version string
+	Config  *WorkerConfig
+
+	RuncVersion string
+	CNIVersion  string
+	QemuImage   string
+	// Base URL to download components from, instead of GitHub
+	ComponentMirror string
+	// Pinned digests of downloaded components
+	Checksums []*ComponentChecksum
+	// Verify components without a pinned digest against the checksums published alongside them
+	AllowPublishedChecksums bool
+
+	// Backend to import and export the cache
+	Cache *CacheBackend
+	// Cache configuration, in the format of _EXPERIMENTAL_DAGGER_CACHE_CONFIG
+	CacheConfig        string
+	DisableServicesDNS bool
+
+	// Where to export OpenTelemetry traces
+	Telemetry *Telemetry
+
+	// Additional CA certificates to trust
+	CACerts    *Directory
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    []string
+
+	// Architectures of the worker image. Defaults to amd64 and arm64.
+	Architectures []string
 }
 
+// Architectures of the worker image, eg. "amd64" or "arm/v7"
 func (w *Worker) Arches() []string {
-	return []string{"amd64", "arm64"}
+	if len(w.Architectures) == 0 {
+		return defaultWorkerArches
+	}
+	return w.Architectures
 }
 
 // Build a worker container for each supported architecture
-func (w *Worker) Containers() []*Container {
+func (w *Worker) Containers() ([]*Container, error) {
 	arches := w.Arches()
 	platformVariants := make([]*Container, 0, len(arches))
 	for _, arch := range arches {
-		platformVariants = append(platformVariants, w.Container(arch))
+		ctr, err := w.Container(arch)
+		if err != nil {
+			return nil, err
+		}
+		platformVariants = append(platformVariants, ctr)
 	}
-	return platformVariants
-}
-
-// Publish the worker container to the given registry
-func (w *Worker) Publish(ctx context.Context, ref string) (string, error) {
-	return dag.Container().Publish(ctx, ref, ContainerPublishOpts{
-		PlatformVariants: w.Containers(),
-	})
+	return platformVariants, nil
 }
 
 // Set the engine version
@@ -56,14 +94,24 @@ func (w *Worker) WithVersion(version string) *Worker {
 	return w
 }
 
-// Build a worker container for the given architecture
-func (w *Worker) Container(arch string) *Container {
-	var opts ContainerOpts
-	if arch != "" {
-		opts.Platform = Platform("linux/" + arch)
+// Configure the worker. See the `workerConfig` function to create a configuration.
+func (w *Worker) WithConfig(config *WorkerConfig) (*Worker, error) {
+	if _, err := config.TOML(); err != nil {
+		return nil, fmt.Errorf("invalid worker config: %w", err)
 	}
-	return dag.Container(opts).
-		From("alpine:"+alpineVersion).
+	w.Config = config
+	return w, nil
+}
+
+// Build a worker container for the given architecture. Defaults to the native architecture.
+func (w *Worker) Container(arch string) (*Container, error) {
+	a := lookupArch(arch)
+	config := w.Config
+	if config == nil {
+		config = devWorkerConfig()
+	}
+	ctr := dag.Container(ContainerOpts{Platform: a.platform()}).
+		From(a.alpineImage()).
 		WithoutDefaultArgs().
 		WithExec([]string{
 			"apk", "add",
@@ -81,28 +129,28 @@ func (w *Worker) Container(arch string) *Container {
 		WithFile("/usr/local/bin/"+daggerBinName, w.DaggerBin(arch)).
 		WithDirectory("/usr/local/bin", w.QemuBins(arch)).
 		WithDirectory("/opt/cni/bin", w.CNIPlugins(arch)).
-		WithDirectory(workerDefaultStateDir, dag.Directory()).
-		WithNewFile(workerTomlPath, ContainerWithNewFileOpts{
-			Contents:    devWorkerConfig(),
-			Permissions: 0o600,
-		}).
+		WithDirectory(workerDefaultStateDir, dag.Directory())
+	ctr, err := config.install(w.withNetwork(ctr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid worker config: %w", err)
+	}
+	return ctr.
 		WithNewFile(workerEntrypointPath, ContainerWithNewFileOpts{
 			Contents:    devWorkerEntrypoint(),
 			Permissions: 0o755,
 		}).
-		WithEntrypoint([]string{"dagger-entrypoint.sh"})
+		WithEntrypoint([]string{"dagger-entrypoint.sh"}), nil
 }
 
 func (w *Worker) QemuBins(arch string) *Directory {
-	return dag.Container(ContainerOpts{Platform: Platform("linux/" + arch)}).
-		From(qemuBinImage).
+	return dag.Container(ContainerOpts{Platform: lookupArch(arch).platform()}).
+		From(w.QemuImage).
 		Rootfs()
 }
 
 func (w *Worker) Buildctl(arch string) *File {
-	return w.GoBase.
+	return lookupArch(arch).goEnv(w.GoBase).
 		WithEnvVariable("GOOS", "linux").
-		WithEnvVariable("GOARCH", arch).
 		WithExec([]string{
 			"go", "build",
 			"-o", "./bin/buildctl",
@@ -113,9 +161,8 @@ func (w *Worker) Buildctl(arch string) *File {
 }
 
 func (w *Worker) Shim(arch string) *File {
-	return w.GoBase.
+	return lookupArch(arch).goEnv(w.GoBase).
 		WithEnvVariable("GOOS", "linux").
-		WithEnvVariable("GOARCH", arch).
 		WithExec([]string{
 			"go", "build",
 			"-o", "./bin/" + shimBinName,
@@ -138,22 +185,19 @@ func (w *Worker) Daemon(arch string) *File {
 	}
 	buildArgs = append(buildArgs, strings.Join(ldflags, " "))
 	buildArgs = append(buildArgs, "/app/cmd/engine")
-	return w.GoBase.
+	return lookupArch(arch).goEnv(w.GoBase).
 		WithEnvVariable("GOOS", "linux").
-		WithEnvVariable("GOARCH", arch).
 		WithExec(buildArgs).
 		File("./bin/" + workerBinName)
 }
 
 func (w *Worker) CNIPlugins(arch string) *Directory {
-	cniURL := fmt.Sprintf(
-		"https://github.com/containernetworking/plugins/releases/download/%s/cni-plugins-%s-%s-%s.tgz",
-		cniVersion, "linux", arch, cniVersion,
-	)
+	archive := fmt.Sprintf("cni-plugins-%s-%s-%s.tgz", "linux", lookupArch(arch).cni, w.CNIVersion)
+	cniURL := w.releaseURL("containernetworking/plugins", w.CNIVersion, archive)
 
 	return dag.Container().
 		From("alpine:"+alpineVersion).
-		WithMountedFile("/tmp/cni-plugins.tgz", dag.HTTP(cniURL)).
+		WithMountedFile("/tmp/cni-plugins.tgz", w.verifiedDownload(w.CNIVersion, cniURL, cniURL+".sha256")).
 		WithDirectory("/opt/cni/bin", dag.Directory()).
 		WithExec([]string{
 			"tar", "-xzf", "/tmp/cni-plugins.tgz",
@@ -167,9 +211,8 @@ func (w *Worker) CNIPlugins(arch string) *Directory {
 }
 
 func (w *Worker) DNSName(arch string) *File {
-	return w.GoBase.
+	return lookupArch(arch).goEnv(w.GoBase).
 		WithEnvVariable("GOOS", "linux").
-		WithEnvVariable("GOARCH", arch).
 		WithExec([]string{
 			"go", "build",
 			"-o", "./bin/dnsname",
@@ -180,37 +223,164 @@ func (w *Worker) DNSName(arch string) *File {
 }
 
 func (w *Worker) Runc(arch string) *File {
-	return dag.HTTP(fmt.Sprintf(
-		"https://github.com/opencontainers/runc/releases/download/%s/runc.%s",
-		runcVersion,
-		arch,
-	))
+	return w.verifiedDownload(
+		w.RuncVersion,
+		w.releaseURL("opencontainers/runc", w.RuncVersion, "runc."+lookupArch(arch).runc),
+		w.releaseURL("opencontainers/runc", w.RuncVersion, "runc.sha256sum"),
+	)
 }
 
 func (w *Worker) DaggerBin(arch string) *File {
-	return w.Engine.CLI("linux", "arch", "", w.Version)
+	return w.Engine.CLI("linux", arch, "", w.Version)
+}
+
+// Run the worker as a long-running service, for use by a Dagger CLI.
+//
+//	The worker listens on tcp port 1234. Its state is persisted in a cache volume.
+func (w *Worker) Service(
+	// Name of the cache volume in which to persist the worker state
+	// +optional
+	// +default="dagger-dev-engine-state"
+	stateVolume string,
+) (*Service, error) {
+	if stateVolume == "" {
+		stateVolume = "dagger-dev-engine-state"
+	}
+	worker, err := w.Container("")
+	if err != nil {
+		return nil, err
+	}
+	return w.asService(worker, stateVolume), nil
+}
+
+// A container with a Dagger CLI, wired to a worker service.
+//
+//	Use it to try the worker by hand, eg. with `dagger call ... playground terminal`.
+func (w *Worker) Playground(
+	// Name of the cache volume in which to persist the worker state
+	// +optional
+	// +default="dagger-dev-engine-state"
+	stateVolume string,
+) (*Container, error) {
+	ctr, err := w.withClient(dag.Container().From("alpine:"+alpineVersion), stateVolume)
+	if err != nil {
+		return nil, err
+	}
+	return ctr.
+		WithWorkdir("/src").
+		WithDefaultTerminalCmd([]string{"sh"}), nil
+}
+
+// Install a matching Dagger CLI in a container, wired to a worker service
+func (w *Worker) withClient(ctr *Container, stateVolume string) (*Container, error) {
+	svc, err := w.Service(stateVolume)
+	if err != nil {
+		return nil, err
+	}
+	if w.Telemetry != nil {
+		ctr = w.Telemetry.Install(ctr)
+	}
+	return ctr.
+		WithFile(daggerCLIPath, w.Engine.CLI("linux", "", "", w.Version), ContainerWithFileOpts{
+			Permissions: 0o755,
+		}).
+		WithServiceBinding("dagger-engine", svc).
+		WithEnvVariable("_EXPERIMENTAL_DAGGER_CLI_BIN", daggerCLIPath).
+		WithEnvVariable("_EXPERIMENTAL_DAGGER_RUNNER_HOST", fmt.Sprintf("tcp://dagger-engine:%d", devWorkerListenPort)), nil
+}
+
+// Run a worker container as a service, listening on the dev worker port.
+// The cache backend and telemetry are wired here rather than in the image,
+// which is published.
+func (w *Worker) asService(worker *Container, stateVolume string) *Service {
+	return w.withRuntime(worker).
+		WithExposedPort(devWorkerListenPort, ContainerWithExposedPortOpts{Protocol: Tcp}).
+		WithMountedCache(workerDefaultStateDir, dag.CacheVolume(stateVolume)).
+		WithExec(nil, ContainerWithExecOpts{
+			InsecureRootCapabilities: true,
+		}).
+		AsService()
 }
 
-// Run all worker tests
-func (w *Worker) Tests(ctx context.Context) error {
-	worker := w.Container("")
+// Run all worker tests, and return a report of the results.
+//
+//	The tests themselves failing does not cause an error: call `check` on the report for that.
+func (w *Worker) Tests(
+	ctx context.Context,
+	// Packages to test
+	// +optional
+	// +default=["./..."]
+	packages []string,
+	// Only run tests matching this regular expression (see `go test -run`)
+	// +optional
+	run string,
+	// Enable the race detector. This also enables CGO.
+	// +optional
+	race bool,
+	// Maximum number of tests to run in parallel (see `go test -parallel`)
+	// +optional
+	// +default=16
+	parallel int,
+	// Timeout for the whole test run (see `go test -timeout`).
+	// Defaults to 15m, or 1h in race mode.
+	// +optional
+	timeout string,
+	// Only run one shard of the tests. Shards are numbered from 1 to `shards`.
+	// +optional
+	shard int,
+	// Split the tests in this many shards
+	// +optional
+	shards int,
+) (*TestReport, error) {
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+	if parallel == 0 {
+		parallel = 16
+	}
+	if timeout == "" {
+		timeout = "15m"
+		if race {
+			timeout = "1h"
+		}
+	}
+	if shards > 0 {
+		if shard < 1 || shard > shards {
+			return nil, fmt.Errorf("invalid shard %d: must be between 1 and %d", shard, shards)
+		}
+		top, sub := splitRunPattern(run)
+		tests, err := w.listTests(ctx, packages, top)
+		if err != nil {
+			return nil, err
+		}
+		run = shardRunPattern(tests, shard, shards)
+		if sub != "" {
+			// keep filtering subtests like the user pattern did
+			run += "/" + sub
+		}
+	} else if shard != 0 {
+		return nil, fmt.Errorf("shard %d requires the number of shards to be set", shard)
+	}
+
+	worker, err := w.Container("")
+	if err != nil {
+		return nil, err
+	}
 	testEngineUtils := dag.
 		Directory().
 		WithFile("engine.tar", worker.AsTarball()).
 		WithFile("dagger", w.Engine.CLI("", "", "", ""), DirectoryWithFileOpts{
 			Permissions: 0755,
 		})
-	workerSvc := worker.
-		WithServiceBinding("registry", registry()).
-		WithServiceBinding("privateregistry", privateRegistry()).
-		WithExposedPort(devWorkerListenPort, ContainerWithExposedPortOpts{Protocol: Tcp}).
-		WithMountedCache(workerDefaultStateDir, dag.CacheVolume("dagger-dev-engine-test-state")).
-		WithExec(nil, ContainerWithExecOpts{
-			InsecureRootCapabilities: true,
-		}).AsService()
+	workerSvc := w.asService(
+		worker.
+			WithServiceBinding("registry", registry()).
+			WithServiceBinding("privateregistry", privateRegistry()),
+		"dagger-dev-engine-test-state",
+	)
 	endpoint, err := workerSvc.Endpoint(ctx, ServiceEndpointOpts{Port: devWorkerListenPort, Scheme: "tcp"})
 	if err != nil {
-		return fmt.Errorf("failed to get dev engine endpoint: %w", err)
+		return nil, fmt.Errorf("failed to get dev engine endpoint: %w", err)
 	}
 
 	cgoEnabledEnv := "0"
@@ -218,40 +388,142 @@ func (w *Worker) Tests(ctx context.Context) error {
 		"gotestsum",
 		"--format", "testname",
 		"--no-color=false",
-		"--jsonfile=./tests.log",
+		"--jsonfile=" + testLogPath,
 		"--",
 		// go test flags
-		"-parallel=16",
+		"-parallel=" + strconv.Itoa(parallel),
 		"-count=1",
-		"-timeout=15m",
+		"-timeout=" + timeout,
 	}
-
-	/* TODO: re-add support
 	if race {
-		args = append(args, "-race", "-timeout=1h")
+		// the race detector requires cgo
+		args = append(args, "-race")
 		cgoEnabledEnv = "1"
 	}
-	*/
-
-	args = append(args, "./...")
+	if run != "" {
+		args = append(args, "-run", run)
+	}
+	args = append(args, packages...)
 	cliBinPath := "/.dagger-cli"
 
 	utilDirPath := "/dagger-dev"
-	_, err = w.GoBase.
+	ctr := w.GoBase.
 		WithExec([]string{"go", "install", "gotest.tools/gotestsum@v1.10.0"}).
 		WithMountedDirectory("/app", w.Engine.Source). // need all the source for extension tests
 		WithMountedDirectory(utilDirPath, testEngineUtils).
 		WithEnvVariable("_DAGGER_TESTS_ENGINE_TAR", filepath.Join(utilDirPath, "engine.tar")).
 		WithWorkdir("/app").
-		WithServiceBinding("dagger-engine", worker.AsService()).
+		WithServiceBinding("dagger-engine", w.withRuntime(worker).AsService()).
 		WithServiceBinding("registry", registry()).
 		WithEnvVariable("CGO_ENABLED", cgoEnabledEnv).
 		WithMountedFile(cliBinPath, w.Engine.CLI("", "", "", "")).
 		WithEnvVariable("_EXPERIMENTAL_DAGGER_CLI_BIN", cliBinPath).
-		WithEnvVariable("_EXPERIMENTAL_DAGGER_RUNNER_HOST", endpoint).
-		WithExec(args).
+		WithEnvVariable("_EXPERIMENTAL_DAGGER_RUNNER_HOST", endpoint)
+	ctr, exitCode, err := execWithExitCode(ctx, ctr, args, "")
+	if err != nil {
+		return nil, err
+	}
+	ctr, err = ctr.
 		WithFocus().
-		WithExec([]string{"gotestsum", "tool", "slowest", "--jsonfile=./tests.log", "--threshold=1s"}).
+		WithExec([]string{"gotestsum", "tool", "slowest", "--jsonfile=" + testLogPath, "--threshold=1s"}).
 		Sync(ctx)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return newTestReport(ctx, ctr.File(testLogPath), exitCode)
+}
+
+// Parse the results of a test run into a report
+func newTestReport(ctx context.Context, log *File, exitCode int) (*TestReport, error) {
+	contents, err := log.Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report, err := parseTestReport(contents)
+	if err != nil {
+		return nil, err
+	}
+	report.Log = log
+	report.ExitCode = exitCode
+	return report, nil
+}
+
+// List the top-level tests in the given packages, optionally filtered by a `go test -run` pattern
+func (w *Worker) listTests(ctx context.Context, packages []string, run string) ([]string, error) {
+	if run == "" {
+		run = "."
+	}
+	out, err := w.GoBase.
+		WithMountedDirectory("/app", w.Engine.Source).
+		WithWorkdir("/app").
+		WithExec(append([]string{"go", "test", "-list", run}, packages...)).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var tests []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		// skip package summaries ("ok  pkg 0.1s", "?  pkg [no test files]")
+		if line == "" || strings.ContainsAny(line, " \t") {
+			continue
+		}
+		if !strings.HasPrefix(line, "Test") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		tests = append(tests, line)
+	}
+	sort.Strings(tests)
+	return tests, nil
+}
+
+// Split a `go test -run` pattern into its top-level part and its subtest part,
+// eg. "TestContainer/alpine" into "TestContainer" and "alpine".
+// Like `go test`, escaped slashes and slashes inside brackets or parentheses don't split.
+func splitRunPattern(run string) (top, sub string) {
+	brackets, parens := 0, 0
+	for i := 0; i < len(run); i++ {
+		switch run[i] {
+		case '[':
+			brackets++
+		case ']':
+			if brackets > 0 {
+				brackets--
+			}
+		case '(':
+			if brackets == 0 {
+				parens++
+			}
+		case ')':
+			if brackets == 0 && parens > 0 {
+				parens--
+			}
+		case '\\':
+			// skip the escaped character
+			i++
+		case '/':
+			if brackets == 0 && parens == 0 {
+				return run[:i], run[i+1:]
+			}
+		}
+	}
+	return run, ""
+}
+
+// Build a `go test -run` pattern selecting one shard of the given tests.
+// Tests are assigned to shards round-robin, in sorted order, so that the split is stable.
+func shardRunPattern(tests []string, shard, shards int) string {
+	var selected []string
+	for i, name := range tests {
+		if i%shards == shard-1 {
+			selected = append(selected, regexp.QuoteMeta(name))
+		}
+	}
+	if len(selected) == 0 {
+		// nothing to run in this shard: match no test
+		return "^$"
+	}
+	return "^(" + strings.Join(selected, "|") + ")$"
 }
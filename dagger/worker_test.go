@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestShardRunPattern(t *testing.T) {
+	tests := []string{"TestA", "TestB", "TestC", "TestD", "TestE"}
+	for _, tc := range []struct {
+		tests  []string
+		shard  int
+		shards int
+		want   string
+	}{
+		{tests, 1, 1, "^(TestA|TestB|TestC|TestD|TestE)$"},
+		{tests, 1, 2, "^(TestA|TestC|TestE)$"},
+		{tests, 2, 2, "^(TestB|TestD)$"},
+		{tests, 3, 3, "^(TestC)$"},
+		{tests[:2], 3, 3, "^$"},
+		{nil, 1, 2, "^$"},
+		{[]string{"TestFoo.Bar"}, 1, 1, `^(TestFoo\.Bar)$`},
+	} {
+		if got := shardRunPattern(tc.tests, tc.shard, tc.shards); got != tc.want {
+			t.Errorf("shardRunPattern(%v, %d, %d) = %q, want %q", tc.tests, tc.shard, tc.shards, got, tc.want)
+		}
+	}
+}
+
+func TestSplitRunPattern(t *testing.T) {
+	for _, tc := range []struct {
+		run string
+		top string
+		sub string
+	}{
+		{"", "", ""},
+		{"TestContainer", "TestContainer", ""},
+		{"TestContainer/alpine", "TestContainer", "alpine"},
+		{"TestContainer/alpine/3.18", "TestContainer", "alpine/3.18"},
+		{"Test[/]Foo/bar", "Test[/]Foo", "bar"},
+		{"Test(a/b)/c", "Test(a/b)", "c"},
+		{`Test\/Foo/bar`, `Test\/Foo`, "bar"},
+	} {
+		top, sub := splitRunPattern(tc.run)
+		if top != tc.top || sub != tc.sub {
+			t.Errorf("splitRunPattern(%q) = %q, %q, want %q, %q", tc.run, top, sub, tc.top, tc.sub)
+		}
+	}
+}
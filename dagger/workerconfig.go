@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const workerCertsDir = "/etc/dagger/certs"
+
+// A new worker configuration, with default settings
+func (d *Dagger) WorkerConfig() *WorkerConfig {
+	return baseWorkerConfig()
+}
+
+// Configuration of an engine worker, rendered to engine.toml
+type WorkerConfig struct {
+	Debug bool
+	// Where the worker stores its state
+	Root string
+	// Entitlements that clients are allowed to request
+	Entitlements []string
+	// Addresses the worker listens on, eg. "tcp://0.0.0.0:1234"
+	GRPCAddresses []string
+	Registries    []*RegistryConfig
+	GCPolicies    []*GCPolicy
+}
+
+// Configuration for pulling from a container registry
+type RegistryConfig struct {
+	// Registry host, eg. "docker.io" or "registry:5000"
+	Host string
+	// Mirrors to pull from instead of the registry itself
+	Mirrors []string
+	// Connect over plain HTTP
+	HTTP bool
+	// Connect over HTTPS, but skip certificate verification
+	Insecure bool
+	// CA certificates to trust when connecting to the registry
+	CACerts []*File
+}
+
+// A policy for garbage-collecting the worker cache
+type GCPolicy struct {
+	// Apply the policy to all cache records, including shared ones
+	All bool
+	// Amount of cache to keep, in bytes
+	KeepBytes int
+	// Cache records older than this are pruned, eg. "48h"
+	KeepDuration string
+	// Only apply the policy to matching cache records, eg. "type==source.local"
+	Filters []string
+}
+
+var (
+	validEntitlements = []string{"security.insecure", "network.host"}
+	validGRPCSchemes  = []string{"unix", "tcp", "tcp4", "tcp6"}
+)
+
+// Enable or disable debug logging
+func (c *WorkerConfig) WithDebug(debug bool) *WorkerConfig {
+	c.Debug = debug
+	return c
+}
+
+// Allow clients to request an entitlement, eg. "security.insecure"
+func (c *WorkerConfig) WithEntitlement(name string) *WorkerConfig {
+	for _, e := range c.Entitlements {
+		if e == name {
+			return c
+		}
+	}
+	c.Entitlements = append(c.Entitlements, name)
+	return c
+}
+
+// Listen on an additional gRPC address, eg. "tcp://0.0.0.0:1234"
+func (c *WorkerConfig) WithGRPCAddress(address string) *WorkerConfig {
+	c.GRPCAddresses = append(c.GRPCAddresses, address)
+	return c
+}
+
+// Replace the gRPC addresses to listen on
+func (c *WorkerConfig) WithGRPCAddresses(addresses []string) *WorkerConfig {
+	c.GRPCAddresses = addresses
+	return c
+}
+
+// Pull from a mirror instead of the given registry
+func (c *WorkerConfig) WithRegistryMirror(
+	// Registry host, eg. "docker.io"
+	host string,
+	// Mirror host, eg. "mirror.gcr.io"
+	mirror string,
+) *WorkerConfig {
+	r := c.registry(host)
+	r.Mirrors = append(r.Mirrors, mirror)
+	return c
+}
+
+// Connect to a registry over plain HTTP
+func (c *WorkerConfig) WithHTTPRegistry(host string) *WorkerConfig {
+	c.registry(host).HTTP = true
+	return c
+}
+
+// Connect to a registry over HTTPS, without verifying its certificate
+func (c *WorkerConfig) WithInsecureRegistry(host string) *WorkerConfig {
+	c.registry(host).Insecure = true
+	return c
+}
+
+// Trust a CA certificate when connecting to a registry
+func (c *WorkerConfig) WithRegistryCA(
+	// Registry host, eg. "registry.example.com"
+	host string,
+	// PEM-encoded CA certificate
+	cert *File,
+) *WorkerConfig {
+	r := c.registry(host)
+	r.CACerts = append(r.CACerts, cert)
+	return c
+}
+
+// Remove all registry settings, including the default mirrors
+func (c *WorkerConfig) WithoutRegistries() *WorkerConfig {
+	c.Registries = nil
+	return c
+}
+
+// Add a policy for garbage-collecting the worker cache
+func (c *WorkerConfig) WithGCPolicy(
+	// Amount of cache to keep, in bytes
+	// +optional
+	keepBytes int,
+	// Cache records older than this are pruned, eg. "48h"
+	// +optional
+	keepDuration string,
+	// Only apply the policy to matching cache records, eg. "type==source.local"
+	// +optional
+	filters []string,
+	// Apply the policy to all cache records, including shared ones
+	// +optional
+	all bool,
+) *WorkerConfig {
+	c.GCPolicies = append(c.GCPolicies, &GCPolicy{
+		All:          all,
+		KeepBytes:    keepBytes,
+		KeepDuration: keepDuration,
+		Filters:      filters,
+	})
+	return c
+}
+
+// Lookup the settings of a registry, creating them if needed
+func (c *WorkerConfig) registry(host string) *RegistryConfig {
+	for _, r := range c.Registries {
+		if r.Host == host {
+			return r
+		}
+	}
+	r := &RegistryConfig{Host: host}
+	c.Registries = append(c.Registries, r)
+	return r
+}
+
+// Check the configuration for errors
+func (c *WorkerConfig) Validate() error {
+	if !path.IsAbs(c.Root) {
+		return fmt.Errorf("root must be an absolute path: %q", c.Root)
+	}
+	for _, e := range c.Entitlements {
+		if !contains(validEntitlements, e) {
+			return fmt.Errorf("unknown entitlement %q: must be one of %s", e, strings.Join(validEntitlements, ", "))
+		}
+	}
+	if len(c.GRPCAddresses) == 0 {
+		return fmt.Errorf("at least one grpc address is required")
+	}
+	for _, addr := range c.GRPCAddresses {
+		if err := validateGRPCAddress(addr); err != nil {
+			return err
+		}
+	}
+	hosts := make(map[string]bool)
+	for _, r := range c.Registries {
+		if r.Host == "" || strings.ContainsAny(r.Host, "/ ") {
+			return fmt.Errorf("invalid registry host %q", r.Host)
+		}
+		if hosts[r.Host] {
+			return fmt.Errorf("registry %q is configured more than once", r.Host)
+		}
+		hosts[r.Host] = true
+		if r.HTTP && len(r.CACerts) > 0 {
+			return fmt.Errorf("registry %q: CA certificates have no effect over plain HTTP", r.Host)
+		}
+		for _, m := range r.Mirrors {
+			if m == "" || strings.Contains(m, "://") {
+				return fmt.Errorf("registry %q: invalid mirror %q: expected a host, eg. mirror.gcr.io", r.Host, m)
+			}
+		}
+	}
+	for i, p := range c.GCPolicies {
+		if p.KeepBytes < 0 {
+			return fmt.Errorf("gc policy %d: keep bytes must be positive", i)
+		}
+		if p.KeepDuration != "" {
+			if d, err := time.ParseDuration(p.KeepDuration); err != nil || d < 0 {
+				return fmt.Errorf("gc policy %d: invalid keep duration %q", i, p.KeepDuration)
+			}
+		}
+		for _, f := range p.Filters {
+			if !strings.Contains(f, "==") && !strings.Contains(f, "~=") && !strings.Contains(f, "!=") {
+				return fmt.Errorf("gc policy %d: invalid filter %q: expected eg. type==source.local", i, f)
+			}
+		}
+	}
+	return nil
+}
+
+func validateGRPCAddress(addr string) error {
+	scheme, rest, ok := strings.Cut(addr, "://")
+	if !ok || !contains(validGRPCSchemes, scheme) {
+		return fmt.Errorf("invalid grpc address %q: expected one of %s://", addr, strings.Join(validGRPCSchemes, "://, "))
+	}
+	if scheme == "unix" {
+		if !path.IsAbs(rest) {
+			return fmt.Errorf("invalid grpc address %q: socket path must be absolute", addr)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(rest); err != nil {
+		return fmt.Errorf("invalid grpc address %q: %w", addr, err)
+	}
+	return nil
+}
+
+// The layout of engine.toml.
+// See https://docs.docker.com/build/buildkit/toml-configuration/
+type engineToml struct {
+	Debug                bool                    `toml:"debug"`
+	Root                 string                  `toml:"root"`
+	InsecureEntitlements []string                `toml:"insecure-entitlements,omitempty"`
+	GRPC                 grpcToml                `toml:"grpc"`
+	Registries           map[string]registryToml `toml:"registry,omitempty"`
+	Worker               *workerToml             `toml:"worker,omitempty"`
+}
+
+type grpcToml struct {
+	Address []string `toml:"address"`
+}
+
+type registryToml struct {
+	Mirrors  []string `toml:"mirrors,omitempty"`
+	HTTP     bool     `toml:"http,omitempty"`
+	Insecure bool     `toml:"insecure,omitempty"`
+	CA       []string `toml:"ca,omitempty"`
+}
+
+type workerToml struct {
+	OCI workerOCIToml `toml:"oci"`
+}
+
+type workerOCIToml struct {
+	GC         bool           `toml:"gc"`
+	GCPolicies []gcPolicyToml `toml:"gcpolicy,omitempty"`
+}
+
+type gcPolicyToml struct {
+	All       bool  `toml:"all,omitempty"`
+	KeepBytes int64 `toml:"keepBytes,omitempty"`
+	// buildkit expects a number of seconds
+	KeepDuration int64    `toml:"keepDuration,omitempty"`
+	Filters      []string `toml:"filters,omitempty"`
+}
+
+// Render the configuration as engine.toml.
+//
+//	The rendered file is parsed back, to make sure it is valid TOML with the expected layout.
+func (c *WorkerConfig) TOML() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c.engineToml()); err != nil {
+		return "", fmt.Errorf("failed to render engine.toml: %w", err)
+	}
+	var decoded engineToml
+	md, err := toml.Decode(buf.String(), &decoded)
+	if err != nil {
+		return "", fmt.Errorf("rendered engine.toml is invalid: %w", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return "", fmt.Errorf("rendered engine.toml has unexpected keys: %v", undecoded)
+	}
+	return buf.String(), nil
+}
+
+func (c *WorkerConfig) engineToml() *engineToml {
+	config := &engineToml{
+		Debug:                c.Debug,
+		Root:                 c.Root,
+		InsecureEntitlements: c.Entitlements,
+		GRPC:                 grpcToml{Address: c.GRPCAddresses},
+	}
+	for _, r := range c.Registries {
+		if config.Registries == nil {
+			config.Registries = make(map[string]registryToml)
+		}
+		registry := registryToml{
+			Mirrors:  r.Mirrors,
+			HTTP:     r.HTTP,
+			Insecure: r.Insecure,
+		}
+		if len(r.CACerts) > 0 {
+			registry.CA = r.caCertPaths()
+		}
+		config.Registries[r.Host] = registry
+	}
+	if len(c.GCPolicies) > 0 {
+		config.Worker = &workerToml{OCI: workerOCIToml{GC: true}}
+		for _, p := range c.GCPolicies {
+			policy := gcPolicyToml{
+				All:       p.All,
+				KeepBytes: int64(p.KeepBytes),
+				Filters:   p.Filters,
+			}
+			if p.KeepDuration != "" {
+				// validated above
+				d, _ := time.ParseDuration(p.KeepDuration)
+				policy.KeepDuration = int64(d.Seconds())
+			}
+			config.Worker.OCI.GCPolicies = append(config.Worker.OCI.GCPolicies, policy)
+		}
+	}
+	return config
+}
+
+// Where the CA certificates of this registry are installed in the worker container
+func (r *RegistryConfig) caCertPaths() []string {
+	dir := path.Join(workerCertsDir, strings.ReplaceAll(r.Host, ":", "_"))
+	paths := make([]string, 0, len(r.CACerts))
+	for i := range r.CACerts {
+		paths = append(paths, path.Join(dir, fmt.Sprintf("ca-%d.pem", i)))
+	}
+	return paths
+}
+
+// Install the configuration, and the files it references, in a worker container
+func (c *WorkerConfig) install(ctr *Container) (*Container, error) {
+	contents, err := c.TOML()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range c.Registries {
+		for i, p := range r.caCertPaths() {
+			ctr = ctr.WithFile(p, r.CACerts[i])
+		}
+	}
+	return ctr.WithNewFile(workerTomlPath, ContainerWithNewFileOpts{
+		Contents:    contents,
+		Permissions: 0o600,
+	}), nil
+}
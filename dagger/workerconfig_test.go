@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestWorkerConfigTOML(t *testing.T) {
+	config := baseWorkerConfig().
+		WithEntitlement("network.host").
+		WithRegistryMirror("docker.io", "mirror.gcr.io").
+		WithHTTPRegistry("registry:5000").
+		WithRegistryCA("registry.example.com", new(File)).
+		WithGCPolicy(1<<30, "48h", []string{"type==source.local"}, true)
+	out, err := config.TOML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if _, err := toml.Decode(out, &got); err != nil {
+		t.Fatalf("invalid TOML: %v\n%s", err, out)
+	}
+	registries := got["registry"].(map[string]any)
+	policy := got["worker"].(map[string]any)["oci"].(map[string]any)["gcpolicy"].([]map[string]any)[0]
+	for _, tc := range []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"debug", got["debug"], true},
+		{"root", got["root"], workerDefaultStateDir},
+		{"entitlements", got["insecure-entitlements"], []any{"security.insecure", "network.host"}},
+		{"grpc addresses", got["grpc"].(map[string]any)["address"], []any{
+			"unix://" + workerDefaultSockPath,
+			"tcp://0.0.0.0:1234",
+		}},
+		{"mirrors", registries["docker.io"].(map[string]any)["mirrors"], []any{"mirror.gcr.io"}},
+		{"http registry", registries["registry:5000"].(map[string]any)["http"], true},
+		{"registry CA", registries["registry.example.com"].(map[string]any)["ca"], []any{
+			"/etc/dagger/certs/registry.example.com/ca-0.pem",
+		}},
+		{"gc policy all", policy["all"], true},
+		{"gc policy keep bytes", policy["keepBytes"], int64(1 << 30)},
+		{"gc policy keep duration", policy["keepDuration"], int64(48 * 60 * 60)},
+		{"gc policy filters", policy["filters"], []any{"type==source.local"}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s = %#v, want %#v", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestWorkerConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		edit  func(*WorkerConfig)
+		valid bool
+	}{
+		{"default", func(c *WorkerConfig) {}, true},
+		{"unix socket", func(c *WorkerConfig) { c.WithGRPCAddresses([]string{"unix:///run/dagger.sock"}) }, true},
+		{"relative root", func(c *WorkerConfig) { c.Root = "var/lib/dagger" }, false},
+		{"unknown entitlement", func(c *WorkerConfig) { c.WithEntitlement("root") }, false},
+		{"no grpc address", func(c *WorkerConfig) { c.WithGRPCAddresses(nil) }, false},
+		{"grpc address without scheme", func(c *WorkerConfig) { c.WithGRPCAddress("0.0.0.0:1234") }, false},
+		{"grpc address without port", func(c *WorkerConfig) { c.WithGRPCAddress("tcp://0.0.0.0") }, false},
+		{"relative unix socket", func(c *WorkerConfig) { c.WithGRPCAddress("unix://dagger.sock") }, false},
+		{"registry host with scheme", func(c *WorkerConfig) { c.WithHTTPRegistry("http://registry") }, false},
+		{"duplicate registry", func(c *WorkerConfig) {
+			c.Registries = append(c.Registries, &RegistryConfig{Host: "docker.io"}, &RegistryConfig{Host: "docker.io"})
+		}, false},
+		{"CA over HTTP", func(c *WorkerConfig) { c.WithHTTPRegistry("registry:5000").WithRegistryCA("registry:5000", new(File)) }, false},
+		{"mirror URL", func(c *WorkerConfig) { c.WithRegistryMirror("docker.io", "https://mirror.gcr.io") }, false},
+		{"negative keep bytes", func(c *WorkerConfig) { c.WithGCPolicy(-1, "", nil, false) }, false},
+		{"invalid keep duration", func(c *WorkerConfig) { c.WithGCPolicy(0, "2 days", nil, false) }, false},
+		{"invalid filter", func(c *WorkerConfig) { c.WithGCPolicy(0, "", []string{"source.local"}, false) }, false},
+	} {
+		c := baseWorkerConfig()
+		tc.edit(c)
+		err := c.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: should be invalid", tc.name)
+		}
+		if _, tomlErr := c.TOML(); (tomlErr == nil) != (err == nil) {
+			t.Errorf("%s: TOML and Validate disagree: %v, %v", tc.name, tomlErr, err)
+		}
+	}
+}
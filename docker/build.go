@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"docker/internal/dagger"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Build an image with 'docker buildx build', and load it into the engine.
+//
+//	The build runs on the attached engine, with BuildKit, so Dockerfile features like
+//	heredocs and RUN --mount are supported.
+//	Building for several platforms at once requires the containerd image store
+//	on the engine, to load the resulting multi-platform image.
+func (c *CLI) Build(
+	ctx context.Context,
+	// The build context
+	source *dagger.Directory,
+	// Path of the Dockerfile, relative to the build context
+	// +optional
+	// +default="Dockerfile"
+	dockerfile string,
+	// Build arguments, as "KEY=VALUE"
+	// +optional
+	buildArgs []string,
+	// The stage to build, in a multi-stage Dockerfile
+	// +optional
+	target string,
+	// Platforms to build for, eg. ["linux/amd64", "linux/arm64"]. Defaults to the engine platform.
+	// +optional
+	platforms []string,
+	// Secrets to expose to RUN --mount=type=secret. Each secret is exposed with its name as id.
+	// +optional
+	secrets []*dagger.Secret,
+	// An SSH agent socket to expose to RUN --mount=type=ssh, as the default SSH id
+	// +optional
+	ssh *dagger.Socket,
+) (*Image, error) {
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	cmd := []string{
+		"docker", "buildx", "build",
+		"--file", path.Join("/build", dockerfile),
+		"--iidfile", "/iid",
+		"--load",
+	}
+	for _, arg := range buildArgs {
+		if key, _, ok := strings.Cut(arg, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid build argument %q: expected KEY=VALUE", arg)
+		}
+		cmd = append(cmd, "--build-arg", arg)
+	}
+	if target != "" {
+		cmd = append(cmd, "--target", target)
+	}
+	if len(platforms) > 0 {
+		cmd = append(cmd, "--platform", strings.Join(platforms, ","))
+	}
+	ctr := c.Container().
+		WithMountedDirectory("/build", source)
+	for _, secret := range secrets {
+		name, err := secret.Name(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if strings.ContainsAny(name, ",=/") {
+			return nil, fmt.Errorf("invalid secret name %q: must not contain ',', '=' or '/'", name)
+		}
+		src := "/run/build-secrets/" + name
+		ctr = ctr.WithMountedSecret(src, secret)
+		cmd = append(cmd, "--secret", "id="+name+",src="+src)
+	}
+	if ssh != nil {
+		ctr = ctr.WithUnixSocket("/run/ssh-agent.sock", ssh)
+		cmd = append(cmd, "--ssh", "default=/run/ssh-agent.sock")
+	}
+	cmd = append(cmd, "/build")
+	localID, err := ctr.
+		WithExec(cmd).
+		File("/iid").
+		Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{
+		Client:  c,
+		LocalID: strings.TrimSpace(localID),
+	}, nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"docker/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A container running on the Docker Engine
+type DockerContainer struct {
+	// +private
+	Client *CLI
+	// The full identifier of the container
+	LocalID string
+	Name    string
+	// The image the container was started from
+	Image string
+}
+
+// Start a container in the background, with 'docker run --detach'.
+//
+//	The engine is started first, and must stay up for the container to keep running:
+//	an ephemeral engine is stopped, with its containers, when the Dagger session ends.
+func (c *CLI) Start(
+	ctx context.Context,
+	// Name of the image to run.
+	// Example: registry.dagger.io/engine
+	name,
+	// Tag of the image to run.
+	// +optional
+	// +default="latest"
+	tag string,
+	// Additional arguments
+	// +optional
+	args []string,
+	// Name of the container. Defaults to a random name.
+	// +optional
+	containerName string,
+	// Environment variables, as "KEY=VALUE"
+	// +optional
+	env []string,
+) (*DockerContainer, error) {
+	if tag == "" {
+		tag = "latest"
+	}
+	if containerName == "" {
+		// a unique name also makes sure the command is never cached
+		suffix, err := randomName(8)
+		if err != nil {
+			return nil, err
+		}
+		containerName = "dagger-" + strings.ToLower(suffix)
+	}
+	// the container lives as long as the engine: don't let it stop with the CLI exec
+	if _, err := c.Engine.Start(ctx); err != nil {
+		return nil, err
+	}
+	cmd := []string{"docker", "run", "--detach", "--name", containerName}
+	for _, e := range env {
+		cmd = append(cmd, "--env", e)
+	}
+	cmd = append(cmd, name+":"+tag)
+	cmd = append(cmd, args...)
+	id, err := c.uncached().WithExec(cmd).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DockerContainer{
+		Client:  c,
+		LocalID: strings.TrimSpace(id),
+		Name:    containerName,
+		Image:   name + ":" + tag,
+	}, nil
+}
+
+// List containers on the engine
+func (c *CLI) Containers(
+	ctx context.Context,
+	// Include stopped containers
+	// +optional
+	all bool,
+) ([]*DockerContainer, error) {
+	cmd := []string{"docker", "container", "list", "--no-trunc", "--format", "{{json .}}"}
+	if all {
+		cmd = append(cmd, "--all")
+	}
+	raw, err := c.uncached().WithExec(cmd).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(raw, "\n")
+	containers := make([]*DockerContainer, 0, len(lines))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var info struct {
+			ID    string
+			Names string
+			Image string
+		}
+		if err := json.Unmarshal([]byte(line), &info); err != nil {
+			return containers, err
+		}
+		containers = append(containers, &DockerContainer{
+			Client:  c,
+			LocalID: info.ID,
+			Name:    info.Names,
+			Image:   info.Image,
+		})
+	}
+	return containers, nil
+}
+
+// The CLI container, for commands whose result depends on the state of the engine
+func (c *CLI) uncached() *dagger.Container {
+	return withCacheBuster(c.Container())
+}
+
+// The logs of the container, stdout and stderr combined
+func (ctr *DockerContainer) Logs(
+	ctx context.Context,
+	// Only return this many lines from the end of the logs
+	// +optional
+	tail int,
+) (string, error) {
+	cmd := `docker logs "$@" 2>&1`
+	args := []string{ctr.LocalID}
+	if tail > 0 {
+		args = []string{"--tail", strconv.Itoa(tail), ctr.LocalID}
+	}
+	return ctr.Client.uncached().
+		WithExec(append([]string{"sh", "-c", cmd, "logs"}, args...)).
+		Stdout(ctx)
+}
+
+// Run a command in the container, with 'docker exec', and return its stdout
+func (ctr *DockerContainer) Exec(
+	ctx context.Context,
+	// The command to run
+	args []string,
+) (string, error) {
+	return ctr.Client.uncached().
+		WithExec(append([]string{"docker", "exec", ctr.LocalID}, args...)).
+		Stdout(ctx)
+}
+
+// Stop the container
+func (ctr *DockerContainer) Stop(
+	ctx context.Context,
+	// Seconds to wait for the container to stop, before killing it
+	// +optional
+	// +default=10
+	timeout int,
+) (*DockerContainer, error) {
+	if timeout == 0 {
+		timeout = 10
+	}
+	_, err := ctr.Client.uncached().
+		WithExec([]string{"docker", "stop", "--time", strconv.Itoa(timeout), ctr.LocalID}).
+		Sync(ctx)
+	return ctr, err
+}
+
+// Remove the container
+func (ctr *DockerContainer) Remove(
+	ctx context.Context,
+	// Remove the container even if it is running
+	// +optional
+	force bool,
+) error {
+	cmd := []string{"docker", "rm"}
+	if force {
+		cmd = append(cmd, "--force")
+	}
+	_, err := ctr.Client.uncached().
+		WithExec(append(cmd, ctr.LocalID)).
+		Sync(ctx)
+	return err
+}
+
+// Wait for the container to exit, and return its exit code
+func (ctr *DockerContainer) ExitCode(ctx context.Context) (int, error) {
+	out, err := ctr.Client.uncached().
+		WithExec([]string{"docker", "wait", ctr.LocalID}).
+		Stdout(ctx)
+	if err != nil {
+		return 0, err
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("invalid exit code %q: %w", out, err)
+	}
+	return code, nil
+}
+
+// Wait for the healthcheck of the container to pass.
+//
+//	Fails if the container has no healthcheck, becomes unhealthy, stops,
+//	or isn't healthy before the timeout.
+func (ctr *DockerContainer) WaitHealthy(
+	ctx context.Context,
+	// How long to wait, eg. "30s"
+	// +optional
+	// +default="60s"
+	timeout string,
+) (*DockerContainer, error) {
+	if timeout == "" {
+		timeout = "60s"
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout %q: %w", timeout, err)
+	}
+	script := fmt.Sprintf(`
+deadline=$(( $(date +%%s) + %d ))
+while true; do
+	state=$(docker inspect --format '{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{else}}none{{end}}' "$1") || exit 1
+	status=${state%%%% *}
+	health=${state#* }
+	case "$status" in
+	running) ;;
+	created|restarting) health=starting ;;
+	*) echo "container is not running: $status" >&2; exit 1 ;;
+	esac
+	case "$health" in
+	healthy) exit 0 ;;
+	none) echo "container has no healthcheck" >&2; exit 1 ;;
+	unhealthy) echo "container is unhealthy" >&2; exit 1 ;;
+	esac
+	if [ "$(date +%%s)" -ge "$deadline" ]; then
+		echo "container not healthy after %s: $state" >&2
+		exit 1
+	fi
+	sleep 1
+done
+`, int(d.Seconds()), timeout)
+	_, err = ctr.Client.uncached().
+		WithExec([]string{"sh", "-c", script, "wait-healthy", ctr.LocalID}).
+		Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ctr, nil
+}
+
+// The state of a container, as reported by 'docker container inspect'
+type ContainerInspection struct {
+	LocalID string
+	Name    string
+	Image   string
+	// One of "created", "running", "paused", "restarting", "removing", "exited" or "dead"
+	Status  string
+	Running bool
+	// Exit code of the last run of the container
+	ExitCode int
+	// Start and finish dates of the last run, in RFC3339 format
+	StartedAt  string
+	FinishedAt string
+	// Status of the healthcheck, if any: "starting", "healthy" or "unhealthy"
+	Health string
+	// IP addresses of the container, one per network
+	IPAddresses []string
+}
+
+// Inspect the state of the container
+func (ctr *DockerContainer) Inspect(ctx context.Context) (*ContainerInspection, error) {
+	raw, err := ctr.Client.uncached().
+		WithExec([]string{"docker", "container", "inspect", "--format", "{{json .}}", ctr.LocalID}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		ID     string `json:"Id"`
+		Name   string
+		Config struct {
+			Image string
+		}
+		State struct {
+			Status     string
+			Running    bool
+			ExitCode   int
+			StartedAt  string
+			FinishedAt string
+			Health     *struct {
+				Status string
+			}
+		}
+		NetworkSettings struct {
+			Networks map[string]struct {
+				IPAddress string
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(raw), &info); err != nil {
+		return nil, fmt.Errorf("invalid container inspection: %w", err)
+	}
+	inspection := &ContainerInspection{
+		LocalID:    info.ID,
+		Name:       strings.TrimPrefix(info.Name, "/"),
+		Image:      info.Config.Image,
+		Status:     info.State.Status,
+		Running:    info.State.Running,
+		ExitCode:   info.State.ExitCode,
+		StartedAt:  info.State.StartedAt,
+		FinishedAt: info.State.FinishedAt,
+	}
+	if info.State.Health != nil {
+		inspection.Health = info.State.Health.Status
+	}
+	networks := make([]string, 0, len(info.NetworkSettings.Networks))
+	for network := range info.NetworkSettings.Networks {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		if ip := info.NetworkSettings.Networks[network].IPAddress; ip != "" {
+			inspection.IPAddresses = append(inspection.IPAddresses, ip)
+		}
+	}
+	return inspection, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const daemonConfigPath = "/etc/docker/daemon.json"
+
+// A new, empty configuration for the Docker Engine daemon
+func (d *Docker) DaemonConfig() *DaemonConfig {
+	return &DaemonConfig{}
+}
+
+// Configuration of the Docker Engine daemon, rendered to /etc/docker/daemon.json.
+// See https://docs.docker.com/reference/cli/dockerd/#daemon-configuration-file
+type DaemonConfig struct {
+	// Registry mirrors for Docker Hub, eg. "https://mirror.gcr.io"
+	RegistryMirrors []string
+	// Registries to reach over plain HTTP, or without verifying their certificate, eg. "registry.internal:5000"
+	InsecureRegistries []string
+	// Storage driver, eg. "overlay2" or "vfs"
+	StorageDriver string
+	// Default log driver of containers, eg. "json-file" or "local"
+	LogDriver string
+	// Options of the log driver, as "key=value"
+	LogOpts []string
+	// Enable experimental features
+	Experimental bool
+	// Address pools to allocate network subnets from
+	DefaultAddressPools []*AddressPool
+}
+
+// A pool of addresses, split into subnets of a fixed size
+type AddressPool struct {
+	// The pool, in CIDR notation, eg. "10.10.0.0/16"
+	Base string
+	// The prefix length of the subnets allocated from the pool, eg. 24
+	Size int
+}
+
+// Add a registry mirror for Docker Hub
+func (c *DaemonConfig) WithRegistryMirror(
+	// URL of the mirror, eg. "https://mirror.gcr.io"
+	mirror string,
+) (*DaemonConfig, error) {
+	u, err := url.Parse(mirror)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid registry mirror URL %q", mirror)
+	}
+	c.RegistryMirrors = append(c.RegistryMirrors, mirror)
+	return c, nil
+}
+
+// Allow pulling from and pushing to a registry over plain HTTP, or without verifying its certificate
+func (c *DaemonConfig) WithInsecureRegistry(
+	// Host of the registry, eg. "registry.internal:5000", or a network in CIDR notation
+	host string,
+) *DaemonConfig {
+	c.InsecureRegistries = append(c.InsecureRegistries, host)
+	return c
+}
+
+// Set the storage driver
+func (c *DaemonConfig) WithStorageDriver(
+	// Name of the driver, eg. "overlay2" or "vfs"
+	driver string,
+) *DaemonConfig {
+	c.StorageDriver = driver
+	return c
+}
+
+// Set the default log driver of containers
+func (c *DaemonConfig) WithLogDriver(
+	// Name of the driver, eg. "json-file" or "local"
+	driver string,
+	// Options of the driver, as "key=value", eg. ["max-size=10m"]
+	// +optional
+	opts []string,
+) (*DaemonConfig, error) {
+	for _, opt := range opts {
+		if key, _, ok := strings.Cut(opt, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid log option %q: expected key=value", opt)
+		}
+	}
+	c.LogDriver = driver
+	c.LogOpts = opts
+	return c, nil
+}
+
+// Enable or disable experimental features
+func (c *DaemonConfig) WithExperimental(enabled bool) *DaemonConfig {
+	c.Experimental = enabled
+	return c
+}
+
+// Add a pool of addresses to allocate network subnets from
+func (c *DaemonConfig) WithDefaultAddressPool(
+	// The pool, in CIDR notation, eg. "10.10.0.0/16"
+	base string,
+	// The prefix length of the subnets allocated from the pool
+	// +optional
+	// +default=24
+	size int,
+) (*DaemonConfig, error) {
+	if size == 0 {
+		size = 24
+	}
+	_, network, err := net.ParseCIDR(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address pool %q: %w", base, err)
+	}
+	ones, bits := network.Mask.Size()
+	if size < ones || size > bits {
+		return nil, fmt.Errorf("invalid subnet size %d for address pool %q: must be between %d and %d", size, base, ones, bits)
+	}
+	c.DefaultAddressPools = append(c.DefaultAddressPools, &AddressPool{Base: base, Size: size})
+	return c, nil
+}
+
+// Render the configuration to JSON, in the format of daemon.json
+func (c *DaemonConfig) JSON() string {
+	type addressPool struct {
+		Base string `json:"base"`
+		Size int    `json:"size"`
+	}
+	config := struct {
+		RegistryMirrors     []string          `json:"registry-mirrors,omitempty"`
+		InsecureRegistries  []string          `json:"insecure-registries,omitempty"`
+		StorageDriver       string            `json:"storage-driver,omitempty"`
+		LogDriver           string            `json:"log-driver,omitempty"`
+		LogOpts             map[string]string `json:"log-opts,omitempty"`
+		Experimental        bool              `json:"experimental,omitempty"`
+		DefaultAddressPools []addressPool     `json:"default-address-pools,omitempty"`
+	}{
+		RegistryMirrors:    c.RegistryMirrors,
+		InsecureRegistries: c.InsecureRegistries,
+		StorageDriver:      c.StorageDriver,
+		LogDriver:          c.LogDriver,
+		Experimental:       c.Experimental,
+	}
+	if len(c.LogOpts) > 0 {
+		config.LogOpts = make(map[string]string, len(c.LogOpts))
+		for _, opt := range c.LogOpts {
+			key, value, _ := strings.Cut(opt, "=")
+			config.LogOpts[key] = value
+		}
+	}
+	for _, pool := range c.DefaultAddressPools {
+		config.DefaultAddressPools = append(config.DefaultAddressPools, addressPool{Base: pool.Base, Size: pool.Size})
+	}
+	// can't fail: the config only holds strings, ints and bools
+	out, _ := json.MarshalIndent(config, "", "  ")
+	return string(out)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The properties of an image, as reported by 'docker image inspect'
+type ImageInspection struct {
+	// The local identifier of the image, eg. "sha256:..."
+	LocalID     string
+	RepoTags    []string
+	RepoDigests []string
+	// Creation date, in RFC3339 format
+	Created      string
+	OS           string
+	Architecture string
+	// Variant of the architecture, eg. "v7" for arm
+	Variant string
+	// Size of the image, in bytes
+	Size   int
+	Config *ImageConfig
+	// Digests of the layers of the root filesystem, bottom first
+	Layers []string
+}
+
+// The default configuration of containers run from an image
+type ImageConfig struct {
+	Env        []string
+	Entrypoint []string
+	Cmd        []string
+	Labels     []*ImageLabel
+	// Exposed ports, eg. "8080/tcp"
+	ExposedPorts []string
+	User         string
+	WorkingDir   string
+}
+
+// A label of an image
+type ImageLabel struct {
+	Name  string
+	Value string
+}
+
+// A layer in the history of an image, as reported by 'docker image history'
+type ImageHistoryEntry struct {
+	// The image created by this step, or "<missing>" when it was built elsewhere
+	LocalID string
+	// Creation date, in RFC3339 format
+	Created string
+	// The command that created the layer
+	CreatedBy string
+	// Size of the layer, in bytes. Zero for steps that only change the configuration.
+	Size    int
+	Comment string
+}
+
+// The name used to refer to the image in docker commands
+func (img *Image) name() string {
+	if img.LocalID != "" {
+		return img.LocalID
+	}
+	tag := img.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	return img.Repository + ":" + tag
+}
+
+// Inspect the image: its configuration, platform, size and layers
+func (img *Image) Inspect(ctx context.Context) (*ImageInspection, error) {
+	raw, err := img.Client.Container().
+		WithExec([]string{"docker", "image", "inspect", "--format", "{{json .}}", img.name()}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseImageInspection(raw)
+}
+
+// Parse the output of 'docker image inspect --format {{json .}}'
+func parseImageInspection(raw string) (*ImageInspection, error) {
+	var info struct {
+		ID           string `json:"Id"`
+		RepoTags     []string
+		RepoDigests  []string
+		Created      string
+		Os           string
+		Architecture string
+		Variant      string
+		Size         int
+		Config       struct {
+			Env          []string
+			Entrypoint   []string
+			Cmd          []string
+			Labels       map[string]string
+			ExposedPorts map[string]struct{}
+			User         string
+			WorkingDir   string
+		}
+		RootFS struct {
+			Layers []string
+		}
+	}
+	if err := json.Unmarshal([]byte(raw), &info); err != nil {
+		return nil, fmt.Errorf("invalid image inspection: %w", err)
+	}
+	config := &ImageConfig{
+		Env:        info.Config.Env,
+		Entrypoint: info.Config.Entrypoint,
+		Cmd:        info.Config.Cmd,
+		User:       info.Config.User,
+		WorkingDir: info.Config.WorkingDir,
+	}
+	for name, value := range info.Config.Labels {
+		config.Labels = append(config.Labels, &ImageLabel{Name: name, Value: value})
+	}
+	sort.Slice(config.Labels, func(i, j int) bool {
+		return config.Labels[i].Name < config.Labels[j].Name
+	})
+	for port := range info.Config.ExposedPorts {
+		config.ExposedPorts = append(config.ExposedPorts, port)
+	}
+	sort.Strings(config.ExposedPorts)
+	return &ImageInspection{
+		LocalID:      info.ID,
+		RepoTags:     info.RepoTags,
+		RepoDigests:  info.RepoDigests,
+		Created:      info.Created,
+		OS:           info.Os,
+		Architecture: info.Architecture,
+		Variant:      info.Variant,
+		Size:         info.Size,
+		Config:       config,
+		Layers:       info.RootFS.Layers,
+	}, nil
+}
+
+// The history of the image, one entry per build step, most recent first
+func (img *Image) History(ctx context.Context) ([]*ImageHistoryEntry, error) {
+	raw, err := img.Client.Container().
+		WithExec([]string{
+			"docker", "image", "history",
+			"--no-trunc",
+			"--human=false",
+			"--format", "{{json .}}",
+			img.name(),
+		}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseImageHistory(raw)
+}
+
+// Parse the output of 'docker image history --human=false --format {{json .}}'
+func parseImageHistory(raw string) ([]*ImageHistoryEntry, error) {
+	var history []*ImageHistoryEntry
+	for _, line := range strings.Split(raw, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		var entry struct {
+			ID        string
+			CreatedAt string
+			CreatedBy string
+			Size      string
+			Comment   string
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("invalid image history: %w", err)
+		}
+		size, err := strconv.Atoi(entry.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid layer size %q: %w", entry.Size, err)
+		}
+		history = append(history, &ImageHistoryEntry{
+			LocalID:   entry.ID,
+			Created:   entry.CreatedAt,
+			CreatedBy: entry.CreatedBy,
+			Size:      size,
+			Comment:   entry.Comment,
+		})
+	}
+	return history, nil
+}
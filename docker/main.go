@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"math/big"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -35,12 +37,35 @@ func (e *Docker) Engine(
 	// Use in combination with `persist`
 	// +optional
 	namespace string,
+	// Configuration of the daemon, rendered to /etc/docker/daemon.json
+	// +optional
+	config *DaemonConfig,
+	// Secure the engine with mutual TLS, on port 2376.
+	// See the `tls` function to generate certificates, and use its server bundle.
+	// +optional
+	tls *ServerTLS,
 ) *dagger.Service {
 	ctr := dag.
 		Container().
 		From(fmt.Sprintf("index.docker.io/docker:%s-dind", version)).
-		WithoutEntrypoint().
-		WithExposedPort(2375)
+		WithoutEntrypoint()
+	args := []string{"dockerd", "--host=unix:///var/run/docker.sock"}
+	if tls != nil {
+		ctr = tls.mount(ctr).WithExposedPort(2376)
+		args = append(args,
+			"--host=tcp://0.0.0.0:2376",
+			"--tlsverify",
+			"--tlscacert="+dockerServerCertPath+"/ca.pem",
+			"--tlscert="+dockerServerCertPath+"/cert.pem",
+			"--tlskey="+dockerServerCertPath+"/key.pem",
+		)
+	} else {
+		ctr = ctr.WithExposedPort(2375)
+		args = append(args, "--host=tcp://0.0.0.0:2375", "--tls=false")
+	}
+	if config != nil {
+		ctr = ctr.WithNewFile(daemonConfigPath, config.JSON())
+	}
 	if persist {
 		volumeName := "docker-engine-state-" + version
 		if namespace != "" {
@@ -51,12 +76,7 @@ func (e *Docker) Engine(
 		ctr = ctr.WithMountedCache("/var/lib/docker", volume, opts)
 	}
 	return ctr.
-		WithExec([]string{
-			"dockerd",
-			"--host=tcp://0.0.0.0:2375",
-			"--host=unix:///var/run/docker.sock",
-			"--tls=false",
-		}, dagger.ContainerWithExecOpts{
+		WithExec(args, dagger.ContainerWithExecOpts{
 			InsecureRootCapabilities: true,
 		}).
 		AsService()
@@ -73,28 +93,47 @@ func (d *Docker) CLI(
 	// By default, run an ephemeral engine.
 	// +optional
 	engine *dagger.Service,
+	// Connect to the engine with mutual TLS, on port 2376.
+	// If no engine is specified, the ephemeral engine is secured with these certificates.
+	// Only the client bundle is kept by the CLI.
+	// +optional
+	tls *TLS,
 ) *CLI {
+	var server *ServerTLS
+	var client *ClientTLS
+	if tls != nil {
+		server, client = tls.Server, tls.Client
+	}
 	if engine == nil {
-		engine = d.Engine(version, true, "")
+		engine = d.Engine(version, true, "", nil, server)
 	}
 	return &CLI{
 		Engine: engine,
+		TLS:    client,
 	}
 }
 
 // A Docker client
 type CLI struct {
 	Engine *dagger.Service
+	// Client certificates, when the engine is secured with mutual TLS
+	TLS *ClientTLS
 }
 
 // Package the Docker CLI into a container, wired to an engine
 func (c *CLI) Container() *dagger.Container {
-	return dag.
+	ctr := dag.
 		Container().
 		From(fmt.Sprintf("index.docker.io/docker:cli")).
 		WithoutEntrypoint().
-		WithServiceBinding("dockerd", c.Engine).
-		WithEnvVariable("DOCKER_HOST", dockerEndpoint)
+		WithServiceBinding(dockerHostname, c.Engine)
+	if c.TLS != nil {
+		return c.TLS.mount(ctr).
+			WithEnvVariable("DOCKER_HOST", dockerTLSEndpoint).
+			WithEnvVariable("DOCKER_TLS_VERIFY", "1").
+			WithEnvVariable("DOCKER_CERT_PATH", dockerClientCertPath)
+	}
+	return ctr.WithEnvVariable("DOCKER_HOST", dockerEndpoint)
 }
 
 // Execute 'docker pull'
@@ -189,6 +228,11 @@ func (c *CLI) Import(
 	}, nil
 }
 
+// Make sure the next commands in a container run again, rather than being cached
+func withCacheBuster(ctr *dagger.Container) *dagger.Container {
+	return ctr.WithEnvVariable("CACHEBUSTER", strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
 func randomName(length int) (string, error) {
 	var letters = []rune("ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789") // Excluding easily confused characters
 	b := make([]rune, length)
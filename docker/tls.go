@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"docker/internal/dagger"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+var (
+	dockerTLSEndpoint = fmt.Sprintf("tcp://%s:2376", dockerHostname)
+	// Where certificates are mounted, in the layout expected by dockerd and the docker CLI
+	dockerServerCertPath = "/certs/server"
+	dockerClientCertPath = "/certs/client"
+)
+
+// Generate certificates to secure a Docker Engine with mutual TLS:
+// a CA, a server certificate for the engine, and a client certificate for the CLI.
+//
+//	Keys are generated in memory, and only ever stored in secrets.
+func (d *Docker) TLS(
+	// Host names of the engine, in addition to the default "dockerd" and "localhost"
+	// +optional
+	hostnames []string,
+	// How long the certificates are valid
+	// +optional
+	// +default="24h"
+	validity string,
+) (*TLS, error) {
+	if validity == "" {
+		validity = "24h"
+	}
+	duration, err := time.ParseDuration(validity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validity %q: %w", validity, err)
+	}
+	prefix, err := randomName(12)
+	if err != nil {
+		return nil, err
+	}
+	notAfter := time.Now().Add(duration)
+
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "docker-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caPair, err := newKeyPair(ca, nil, notAfter)
+	if err != nil {
+		return nil, err
+	}
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dockerHostname},
+		DNSNames:    append([]string{dockerHostname, "localhost"}, hostnames...),
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverPair, err := newKeyPair(server, caPair, notAfter)
+	if err != nil {
+		return nil, err
+	}
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "docker-client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientPair, err := newKeyPair(client, caPair, notAfter)
+	if err != nil {
+		return nil, err
+	}
+	secret := func(name string, pem []byte) *dagger.Secret {
+		return dag.SetSecret(fmt.Sprintf("docker-tls-%s-%s", prefix, name), string(pem))
+	}
+	caCert := secret("ca-cert", caPair.certPEM())
+	return &TLS{
+		Server: &ServerTLS{
+			CACert: caCert,
+			Cert:   secret("server-cert", serverPair.certPEM()),
+			Key:    secret("server-key", serverPair.keyPEM),
+		},
+		Client: &ClientTLS{
+			CACert: caCert,
+			Cert:   secret("client-cert", clientPair.certPEM()),
+			Key:    secret("client-key", clientPair.keyPEM),
+		},
+	}, nil
+}
+
+// Certificates securing a Docker Engine with mutual TLS
+type TLS struct {
+	// The bundle of the engine
+	Server *ServerTLS
+	// The bundle of the CLI
+	Client *ClientTLS
+}
+
+// The certificates of a Docker Engine, in PEM format
+type ServerTLS struct {
+	// The CA that signed the client certificates
+	CACert *dagger.Secret
+	Cert   *dagger.Secret
+	Key    *dagger.Secret
+}
+
+// Mount the server certificates in an engine container
+func (t *ServerTLS) mount(ctr *dagger.Container) *dagger.Container {
+	return ctr.
+		WithMountedSecret(dockerServerCertPath+"/ca.pem", t.CACert).
+		WithMountedSecret(dockerServerCertPath+"/cert.pem", t.Cert).
+		WithMountedSecret(dockerServerCertPath+"/key.pem", t.Key)
+}
+
+// The certificates of a Docker CLI, in PEM format
+type ClientTLS struct {
+	// The CA that signed the engine certificate
+	CACert *dagger.Secret
+	Cert   *dagger.Secret
+	Key    *dagger.Secret
+}
+
+// Mount the client certificates in a CLI container
+func (t *ClientTLS) mount(ctr *dagger.Container) *dagger.Container {
+	return ctr.
+		WithMountedSecret(dockerClientCertPath+"/ca.pem", t.CACert).
+		WithMountedSecret(dockerClientCertPath+"/cert.pem", t.Cert).
+		WithMountedSecret(dockerClientCertPath+"/key.pem", t.Key)
+}
+
+// A certificate and its private key
+type keyPair struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	keyPEM []byte
+}
+
+func (p *keyPair) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.cert.Raw})
+}
+
+// Generate a key, and a certificate signed by the parent.
+// The certificate is self-signed if parent is nil.
+func newKeyPair(template *x509.Certificate, parent *keyPair, notAfter time.Time) (*keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	// tolerate clock skew between the engine and its clients
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = notAfter
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{
+		cert:   cert,
+		key:    key,
+		keyPEM: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}